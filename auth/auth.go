@@ -67,6 +67,24 @@ func ValidateJWT(tokenString string, username string, password string) (bool, er
 	return true, nil
 }
 
+//Owner function - returns the owner of the jobs queued with the token, the user it
+//was issued to. Returns an empty string for invalid tokens.
+func Owner(tokenString string) string {
+	token, err := jwt.ParseWithClaims(tokenString, &customClaims{}, func(token *jwt.Token) (interface{}, error) {
+		return signingKey, nil
+	})
+	if err != nil {
+		return ""
+	}
+
+	claims, ok := token.Claims.(*customClaims)
+	if !ok || !token.Valid {
+		return ""
+	}
+
+	return claims.Username
+}
+
 //Middleware decodes the share session cookie and packs the session into context
 func Middleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
@@ -62,4 +62,12 @@ func TestAuth(t *testing.T) {
 		require.NotEqual(t, nil, err)
 		require.Equal(t, "invalid token", err.Error())
 	})
+	t.Run("owner_success", func(t *testing.T) {
+
+		token, err := CreateJWT("secureworks", "supersecret", 15)
+		require.Equal(t, nil, err)
+		require.Equal(t, "secureworks", Owner(token))
+
+		require.Equal(t, "", Owner(token[1:]))
+	})
 }
@@ -72,12 +72,15 @@ func findConfig() string {
 
 //File struct
 type File struct {
-	Server   Server   `json:"server"`
-	Logger   Logger   `json:"logger"`
-	Database Database `json:"db"`
-	Dnsbl    Dnsbl    `json:"dnsbl"`
-	Auth     Auth     `json:"auth"`
-	JobQueue JobQueue `json:"job_queue"`
+	Server    Server    `json:"server"`
+	Logger    Logger    `json:"logger"`
+	Database  Database  `json:"db"`
+	Dnsbl     Dnsbl     `json:"dnsbl"`
+	Auth      Auth      `json:"auth"`
+	JobQueue  JobQueue  `json:"job_queue"`
+	Refresh   Refresh   `json:"refresh"`
+	LookupNow LookupNow `json:"lookup_now"`
+	DNSServer DNSServer `json:"dns_server"`
 }
 
 //Server type
@@ -99,7 +102,45 @@ type Database struct {
 
 //Dnsbl type
 type Dnsbl struct {
-	BlocklistDomains []string `json:"blocklist_domains"`
+	BlocklistDomains       []string `json:"blocklist_domains"`
+	DomainBlocklistDomains []string `json:"domain_blocklist_domains"`
+	AllowlistDomains       []string `json:"allowlist_domains"`
+	VerdictPolicy          string   `json:"verdict_policy"`
+	Nameservers            []string `json:"nameservers"`
+	QueryTimeoutMs         int      `json:"query_timeout_ms"`
+	QueryRetries           int      `json:"query_retries"`
+	MaxConcurrentQueries   int      `json:"max_concurrent_queries"`
+	FetchTXTRecords        bool     `json:"fetch_txt_records"`
+	ScoreThreshold         float64  `json:"score_threshold"`
+	CacheSize              int      `json:"cache_size"`
+
+	//ZoneReloadIntervalSeconds is how often the zone files of the blocklists are checked for changes
+	ZoneReloadIntervalSeconds int `json:"zone_reload_interval_seconds"`
+
+	//Blocklists holds optional settings for each blocklist and allowlist domain, keyed by domain
+	Blocklists map[string]Blocklist `json:"blocklists"`
+}
+
+//Blocklist type - settings for a single blocklist or allowlist domain
+type Blocklist struct {
+	SupportsIPV6 bool         `json:"supports_ipv6"`
+	Weight       *float64     `json:"weight"`
+	ReturnCodes  []ReturnCode `json:"return_codes"`
+
+	//ErrorCodes are answered by the blocklist domain instead of a listing when it refuses the query
+	ErrorCodes []string `json:"error_codes"`
+
+	//ZoneFile is the path of an rbldnsd zone file to look addresses up in instead of querying the blocklist domain
+	ZoneFile   string `json:"zone_file"`
+	ZoneFormat string `json:"zone_format"`
+}
+
+//ReturnCode type - category, severity and weight of one or more blocklist return codes
+type ReturnCode struct {
+	Codes    []string `json:"codes"`
+	Category string   `json:"category"`
+	Severity string   `json:"severity"`
+	Weight   *float64 `json:"weight"`
 }
 
 //Auth type
@@ -107,9 +148,80 @@ type Auth struct {
 	Username           string `json:"username"`
 	Password           string `json:"password"`
 	ExpirationDuration int    `json:"expiration_duration"`
+	Users              []User `json:"users"`
+}
+
+//User type - a user authenticated besides the one of the auth section, which is
+//always an operator
+type User struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Operator bool   `json:"operator"`
 }
 
 //JobQueue type
 type JobQueue struct {
 	QueueLength int `json:"queue_length"`
+
+	//MaxEnqueueAddresses is the largest number of ip addresses a single enqueue may expand to
+	MaxEnqueueAddresses int `json:"max_enqueue_addresses"`
+
+	//Workers is the number of jobs processed at once, and JobParallelism the number of
+	//ip addresses or domains of a single job looked up at once
+	Workers        int `json:"workers"`
+	JobParallelism int `json:"job_parallelism"`
+
+	//MaxInFlightLookups bounds the lookups in flight across all workers
+	MaxInFlightLookups int `json:"max_inflight_lookups"`
+
+	//StopPolicy is "drain" to finish all queued jobs on stop, or "abandon" to stop after the lookups in flight
+	StopPolicy         string `json:"stop_policy"`
+	StopTimeoutSeconds int    `json:"stop_timeout_seconds"`
+
+	//Store is "memory" to hold the queued jobs in memory, or "database" to hold them in
+	//the database, where workers lease them for LeaseSeconds at a time, polling for
+	//new jobs every PollIntervalMs
+	Store          string `json:"store"`
+	LeaseSeconds   int    `json:"lease_seconds"`
+	PollIntervalMs int    `json:"poll_interval_ms"`
+
+	//MaxAttempts bounds the lookups of an ip address or domain failing with an ERROR or
+	//TIMEOUT, retried after a backoff doubling from RetryBackoffMs up to RetryMaxBackoffMs
+	MaxAttempts       int `json:"max_attempts"`
+	RetryBackoffMs    int `json:"retry_backoff_ms"`
+	RetryMaxBackoffMs int `json:"retry_max_backoff_ms"`
+
+	//FreshnessSeconds is the age below which a stored record is reused instead of
+	//looking its ip address or domain up again, 0 to always look it up
+	FreshnessSeconds int `json:"freshness_seconds"`
+
+	//Admission is "reject" to reject a job the instant the queue is full, or "wait" to
+	//wait up to AdmissionTimeoutMs for the queue to make room for it
+	Admission          string `json:"admission"`
+	AdmissionTimeoutMs int    `json:"admission_timeout_ms"`
+}
+
+//Refresh type - every IntervalSeconds, up to BatchSize records not looked up for
+//MaxAgeSeconds are queued to be looked up again
+type Refresh struct {
+	Enabled         bool `json:"enabled"`
+	MaxAgeSeconds   int  `json:"max_age_seconds"`
+	IntervalSeconds int  `json:"interval_seconds"`
+	BatchSize       int  `json:"batch_size"`
+}
+
+//LookupNow type - the lookups of the lookupNow query end after TimeoutMs, and are
+//limited to RequestsPerMinute, up to Burst of them at once
+type LookupNow struct {
+	TimeoutMs         int `json:"timeout_ms"`
+	RequestsPerMinute int `json:"requests_per_minute"`
+	Burst             int `json:"burst"`
+}
+
+//DNSServer type
+type DNSServer struct {
+	Enabled       bool   `json:"enabled"`
+	ListenAddress string `json:"listen_address"`
+	Zone          string `json:"zone"`
+	TTL           int    `json:"ttl"`
 }
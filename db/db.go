@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/egreen64/codingchallenge/config"
@@ -14,6 +15,181 @@ import (
 	_ "github.com/mattn/go-sqlite3" //Required for sql driver registration
 )
 
+//schema contains the statements used to create the database tables. Each statement
+//is safe to execute against an existing database.
+var schema = []string{
+	`
+		CREATE TABLE IF NOT EXISTS dns_blocklist (
+			ip_address TEXT PRIMARY KEY NOT NULL, 
+			id TEXT NOT NULL, 
+			response_code text,
+			created_at DATETIME CURRENT_TIMESTAMP, 
+			updated_at DATETIME CURRENT_TIMESTAMP,
+			listed BOOLEAN NOT NULL DEFAULT 0,
+			categories TEXT NOT NULL DEFAULT '',
+			severity TEXT NOT NULL DEFAULT 'NONE',
+			reason TEXT,
+			verdict TEXT NOT NULL DEFAULT 'NEUTRAL',
+			score REAL NOT NULL DEFAULT 0,
+			blocked BOOLEAN NOT NULL DEFAULT 0,
+			lookup_status TEXT NOT NULL DEFAULT 'NOT_LISTED'
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS dns_blocklist_listing (
+			ip_address TEXT NOT NULL REFERENCES dns_blocklist(ip_address) ON DELETE CASCADE,
+			blocklist_domain TEXT NOT NULL,
+			allowlist BOOLEAN NOT NULL DEFAULT 0,
+			listed BOOLEAN NOT NULL,
+			lookup_status TEXT NOT NULL DEFAULT 'NOT_LISTED',
+			response_code TEXT,
+			reason TEXT,
+			updated_at DATETIME CURRENT_TIMESTAMP,
+			PRIMARY KEY (ip_address, blocklist_domain)
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS dns_domain_blocklist (
+			domain TEXT PRIMARY KEY NOT NULL,
+			id TEXT NOT NULL,
+			response_code TEXT,
+			created_at DATETIME CURRENT_TIMESTAMP,
+			updated_at DATETIME CURRENT_TIMESTAMP,
+			listed BOOLEAN NOT NULL DEFAULT 0,
+			categories TEXT NOT NULL DEFAULT '',
+			severity TEXT NOT NULL DEFAULT 'NONE',
+			reason TEXT,
+			verdict TEXT NOT NULL DEFAULT 'NEUTRAL',
+			score REAL NOT NULL DEFAULT 0,
+			blocked BOOLEAN NOT NULL DEFAULT 0,
+			lookup_status TEXT NOT NULL DEFAULT 'NOT_LISTED'
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS dns_domain_blocklist_listing (
+			domain TEXT NOT NULL REFERENCES dns_domain_blocklist(domain) ON DELETE CASCADE,
+			blocklist_domain TEXT NOT NULL,
+			allowlist BOOLEAN NOT NULL DEFAULT 0,
+			listed BOOLEAN NOT NULL,
+			lookup_status TEXT NOT NULL DEFAULT 'NOT_LISTED',
+			response_code TEXT,
+			reason TEXT,
+			updated_at DATETIME CURRENT_TIMESTAMP,
+			PRIMARY KEY (domain, blocklist_domain)
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS dns_blocklist_history (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			ip_address TEXT NOT NULL REFERENCES dns_blocklist(ip_address) ON DELETE CASCADE,
+			changed_at DATETIME CURRENT_TIMESTAMP,
+			response_code TEXT,
+			listed BOOLEAN NOT NULL,
+			categories TEXT NOT NULL,
+			severity TEXT NOT NULL,
+			score REAL NOT NULL,
+			blocked BOOLEAN NOT NULL
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS dns_domain_blocklist_history (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			domain TEXT NOT NULL REFERENCES dns_domain_blocklist(domain) ON DELETE CASCADE,
+			changed_at DATETIME CURRENT_TIMESTAMP,
+			response_code TEXT,
+			listed BOOLEAN NOT NULL,
+			categories TEXT NOT NULL,
+			severity TEXT NOT NULL,
+			score REAL NOT NULL,
+			blocked BOOLEAN NOT NULL
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS job (
+			id TEXT PRIMARY KEY NOT NULL,
+			kind TEXT NOT NULL,
+			state TEXT NOT NULL,
+			priority TEXT NOT NULL DEFAULT 'NORMAL',
+			owner TEXT NOT NULL DEFAULT '',
+			created_at DATETIME CURRENT_TIMESTAMP,
+			updated_at DATETIME CURRENT_TIMESTAMP
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS job_item (
+			job_id TEXT NOT NULL REFERENCES job(id) ON DELETE CASCADE,
+			position INTEGER NOT NULL,
+			target TEXT NOT NULL,
+			state TEXT NOT NULL,
+			lookup_status TEXT,
+			cached BOOLEAN NOT NULL DEFAULT 0,
+			updated_at DATETIME CURRENT_TIMESTAMP,
+			PRIMARY KEY (job_id, position)
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS job_queue (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			job_id TEXT NOT NULL UNIQUE REFERENCES job(id) ON DELETE CASCADE,
+			priority INTEGER NOT NULL DEFAULT 1,
+			owner TEXT NOT NULL DEFAULT '',
+			turn INTEGER NOT NULL DEFAULT 0,
+			lease_owner TEXT,
+			lease_expires_at INTEGER,
+			attempts INTEGER NOT NULL DEFAULT 0
+		);
+	`,
+	`
+		CREATE TABLE IF NOT EXISTS dead_letter (
+			kind TEXT NOT NULL,
+			target TEXT NOT NULL,
+			job_id TEXT NOT NULL,
+			attempts INTEGER NOT NULL,
+			lookup_status TEXT NOT NULL,
+			created_at DATETIME CURRENT_TIMESTAMP,
+			updated_at DATETIME CURRENT_TIMESTAMP,
+			PRIMARY KEY (kind, target)
+		);
+	`,
+}
+
+//column type - a column added to a table after the table was first released, and
+//the statement setting its value for the rows existing when it is added
+type column struct {
+	table      string
+	name       string
+	definition string
+	backfill   string
+}
+
+//migrations contains the columns added to existing tables, which are added on open
+//to databases created by an earlier release
+var migrations = []column{
+	{"dns_blocklist", "listed", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"dns_blocklist", "categories", "TEXT NOT NULL DEFAULT ''", ""},
+	{"dns_blocklist", "severity", "TEXT NOT NULL DEFAULT 'NONE'", ""},
+	{"dns_blocklist", "reason", "TEXT", ""},
+	{"dns_blocklist_listing", "reason", "TEXT", ""},
+	{"dns_blocklist", "verdict", "TEXT NOT NULL DEFAULT 'NEUTRAL'", ""},
+	{"dns_blocklist_listing", "allowlist", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"dns_domain_blocklist", "verdict", "TEXT NOT NULL DEFAULT 'NEUTRAL'", ""},
+	{"dns_domain_blocklist_listing", "allowlist", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"dns_blocklist", "score", "REAL NOT NULL DEFAULT 0", ""},
+	{"dns_blocklist", "blocked", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"dns_domain_blocklist", "score", "REAL NOT NULL DEFAULT 0", ""},
+	{"dns_domain_blocklist", "blocked", "BOOLEAN NOT NULL DEFAULT 0", ""},
+	{"dns_blocklist", "lookup_status", "TEXT NOT NULL DEFAULT 'NOT_LISTED'", "UPDATE dns_blocklist SET lookup_status = 'LISTED' WHERE listed"},
+	{"dns_blocklist_listing", "lookup_status", "TEXT NOT NULL DEFAULT 'NOT_LISTED'", "UPDATE dns_blocklist_listing SET lookup_status = 'LISTED' WHERE listed"},
+	{"dns_domain_blocklist", "lookup_status", "TEXT NOT NULL DEFAULT 'NOT_LISTED'", "UPDATE dns_domain_blocklist SET lookup_status = 'LISTED' WHERE listed"},
+	{"dns_domain_blocklist_listing", "lookup_status", "TEXT NOT NULL DEFAULT 'NOT_LISTED'", "UPDATE dns_domain_blocklist_listing SET lookup_status = 'LISTED' WHERE listed"},
+	{"job", "priority", "TEXT NOT NULL DEFAULT 'NORMAL'", ""},
+	{"job", "owner", "TEXT NOT NULL DEFAULT ''", ""},
+	{"job_queue", "priority", "INTEGER NOT NULL DEFAULT 1", ""},
+	{"job_queue", "owner", "TEXT NOT NULL DEFAULT ''", ""},
+	{"job_queue", "turn", "INTEGER NOT NULL DEFAULT 0", ""},
+	{"job_item", "cached", "BOOLEAN NOT NULL DEFAULT 0", ""},
+}
+
 //Database type
 type Database struct {
 	dbPath string
@@ -28,29 +204,34 @@ func NewDatabase(config *config.File) *Database {
 		os.Remove(config.Database.DbPath)
 	}
 
-	db, err := sql.Open(config.Database.DbType, config.Database.DbPath)
+	//sqlite only enforces the foreign keys of the schema, and their cascading deletes,
+	//when asked to on each connection
+	db, err := sql.Open(config.Database.DbType, config.Database.DbPath+"?_foreign_keys=1")
 	if err != nil {
 		// This will not be a connection error, but a DSN parse error or
 		// another initialization error.
 		log.Fatalf("unable to open database data source %s, error:%s\n", config.Database.DbPath, err)
 	}
-	if !utils.FileExists(config.Database.DbPath) {
-		sqlStmt := `
-			CREATE TABLE IF NOT EXISTS dns_blocklist (
-				ip_address TEXT PRIMARY KEY NOT NULL, 
-				id TEXT NOT NULL, 
-				response_code text,
-				created_at DATETIME CURRENT_TIMESTAMP, 
-				updated_at DATETIME CURRENT_TIMESTAMP
-			);
-		`
+
+	//sqlite allows a single writer at a time, so the job queue workers share one
+	//connection rather than failing with "database is locked"
+	db.SetMaxOpenConns(1)
+
+	for _, sqlStmt := range schema {
 		_, err = db.Exec(sqlStmt)
 		if err != nil {
-			log.Printf("unable to create database table dns_blocklist, error: %s\n", err)
+			log.Printf("unable to create database schema, error: %s\n", err)
 			log.Fatalf("create table sql statement: %s\n", sqlStmt)
 		}
 	}
 
+	for _, col := range migrations {
+		err = addColumn(db, col)
+		if err != nil {
+			log.Fatalf("unable to add column %s to database table %s, error: %s\n", col.name, col.table, err)
+		}
+	}
+
 	log.Printf("datbase %s succesfully opened\n", config.Database.DbPath)
 
 	dbi := Database{
@@ -61,6 +242,42 @@ func NewDatabase(config *config.File) *Database {
 	return &dbi
 }
 
+//addColumn adds col to its table and backfills it unless the table already has it
+func addColumn(db *sql.DB, col column) error {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", col.table))
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var cid, notNull, pk int
+		var name, colType string
+		var defaultValue sql.NullString
+
+		err = rows.Scan(&cid, &name, &colType, &notNull, &defaultValue, &pk)
+		if err != nil {
+			return err
+		}
+		if name == col.name {
+			return nil
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+	rows.Close()
+
+	_, err = db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", col.table, col.name, col.definition))
+	if err != nil || col.backfill == "" {
+		return err
+	}
+
+	_, err = db.Exec(col.backfill)
+	return err
+}
+
 //CloseDatabase function
 func (db *Database) CloseDatabase() {
 	log.Printf("closing database %s\n", db.dbPath)
@@ -68,91 +285,531 @@ func (db *Database) CloseDatabase() {
 	log.Printf("database %s closed\n", db.dbPath)
 }
 
-//UpsertRecord function
+//recordTable type - the tables holding one kind of blocklist record. Records for
+//ip addresses and for domains share the same layout, differing only in the table
+//names and the column holding the ip address or domain that was looked up.
+type recordTable struct {
+	table        string
+	listingTable string
+	historyTable string
+	keyColumn    string
+	keyName      string
+}
+
+var (
+	ipRecordTable     = recordTable{"dns_blocklist", "dns_blocklist_listing", "dns_blocklist_history", "ip_address", "ip address"}
+	domainRecordTable = recordTable{"dns_domain_blocklist", "dns_domain_blocklist_listing", "dns_domain_blocklist_history", "domain", "domain"}
+)
+
+//UpsertRecord function - inserts or updates the record together with its blocklist listings.
+//The ip address is stored in its canonical form, so that every spelling of an IPV6
+//address refers to the same record.
 func (db *Database) UpsertRecord(record *model.DNSBlockListRecord) error {
+	record.IPAddress = utils.CanonicalIPAddress(record.IPAddress)
+
+	return db.upsertRecord(ipRecordTable, record)
+}
+
+//SelectRecord function
+func (db *Database) SelectRecord(ipAddress string) (*model.DNSBlockListRecord, error) {
+	return db.selectRecord(ipRecordTable, utils.CanonicalIPAddress(ipAddress))
+}
 
-	sqlStmt := `
-		INSERT INTO dns_blocklist(
+//Listing type - whether an ip address is listed, with the response code and reason
+//of its record
+type Listing struct {
+	Listed       bool
+	ResponseCode string
+	Reason       *string
+}
+
+//SelectListing function - reads only the listing of the ip address, for answering DNS
+//queries. Returns nil, without logging, if the ip address has no record, as most of
+//the addresses queried are not listed.
+func (db *Database) SelectListing(ipAddress string) (*Listing, error) {
+	var listing Listing
+
+	err := db.db.QueryRow(`
+		SELECT listed, response_code, reason FROM dns_blocklist WHERE ip_address = ?
+	`, utils.CanonicalIPAddress(ipAddress)).Scan(&listing.Listed, &listing.ResponseCode, &listing.Reason)
+
+	switch {
+	case err == sql.ErrNoRows:
+		return nil, nil
+	case err != nil:
+		log.Printf("unexpected database select error for listing of ip address %s, error: %s", ipAddress, err)
+		err := fmt.Errorf("unexpected query failure encountered for ip address %s", ipAddress)
+		return nil, err
+	}
+
+	return &listing, nil
+}
+
+//StaleRecord type - identifies a record by its ip address and when it was last updated
+type StaleRecord struct {
+	IPAddress string
+	UpdatedAt time.Time
+}
+
+//SelectStaleRecords function - reads up to limit records last updated before the time,
+//least recently updated first. Unless after is nil, the records read start after it,
+//so that the records can be read in batches.
+func (db *Database) SelectStaleRecords(before time.Time, after *StaleRecord, limit int) ([]StaleRecord, error) {
+	var afterUpdatedAt, afterIPAddress string
+	if after != nil {
+		afterUpdatedAt = after.UpdatedAt.Format(time.RFC3339)
+		afterIPAddress = after.IPAddress
+	}
+
+	rows, err := db.db.Query(`
+		SELECT ip_address, updated_at FROM dns_blocklist
+		WHERE updated_at < ? AND (updated_at > ? OR (updated_at = ? AND ip_address > ?))
+		ORDER BY updated_at, ip_address
+		LIMIT ?
+	`, before.Format(time.RFC3339), afterUpdatedAt, afterUpdatedAt, afterIPAddress, limit)
+	if err != nil {
+		log.Printf("unexpected database select error for stale records, error: %s", err)
+		err := fmt.Errorf("unexpected query failure encountered for stale records")
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	records := []StaleRecord{}
+	for rows.Next() {
+		var record StaleRecord
+		var updatedAt string
+
+		err = rows.Scan(&record.IPAddress, &updatedAt)
+		if err != nil {
+			log.Printf("unexpected database scan error for stale records, error: %s", err)
+			err := fmt.Errorf("unexpected query failure encountered for stale records")
+			return nil, err
+		}
+		record.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		records = append(records, record)
+	}
+
+	return records, rows.Err()
+}
+
+//UpsertDomainRecord function - inserts or updates the domain record together with its
+//blocklist listings. The domain is stored in its normalized IDNA form.
+func (db *Database) UpsertDomainRecord(record *model.DNSDomainBlockListRecord) error {
+	record.Domain = utils.NormalizeDomain(record.Domain)
+
+	return db.upsertRecord(domainRecordTable, &model.DNSBlockListRecord{
+		UUID:         record.UUID,
+		IPAddress:    record.Domain,
+		ResponseCode: record.ResponseCode,
+		Listed:       record.Listed,
+		Categories:   record.Categories,
+		Severity:     record.Severity,
+		Reason:       record.Reason,
+		Score:        record.Score,
+		Blocked:      record.Blocked,
+		LookupStatus: record.LookupStatus,
+		Listings:     record.Listings,
+	})
+}
+
+//SelectDomainRecord function
+func (db *Database) SelectDomainRecord(domain string) (*model.DNSDomainBlockListRecord, error) {
+	dblRec, err := db.selectRecord(domainRecordTable, utils.NormalizeDomain(domain))
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.DNSDomainBlockListRecord{
+		UUID:         dblRec.UUID,
+		CreatedAt:    dblRec.CreatedAt,
+		UpdatedAt:    dblRec.UpdatedAt,
+		ResponseCode: dblRec.ResponseCode,
+		Domain:       dblRec.IPAddress,
+		Listed:       dblRec.Listed,
+		Categories:   dblRec.Categories,
+		Severity:     dblRec.Severity,
+		Reason:       dblRec.Reason,
+		Score:        dblRec.Score,
+		Blocked:      dblRec.Blocked,
+		LookupStatus: dblRec.LookupStatus,
+		Listings:     dblRec.Listings,
+		History:      dblRec.History,
+	}, nil
+}
+
+//upsertRecord stores record in the tables of t, using record.IPAddress as the key.
+//A record or listing whose lookup failed does not replace one whose lookup succeeded,
+//so that a transient resolver failure never clears a previous result. A result that
+//differs from the one it replaces is added to the history of the record.
+func (db *Database) upsertRecord(t recordTable, record *model.DNSBlockListRecord) error {
+
+	sqlStmt := fmt.Sprintf(`
+		INSERT INTO %[1]s(
 			id,
-			ip_address,
+			%[2]s,
 			response_code,
+			listed,
+			categories,
+			severity,
+			reason,
+			verdict,
+			score,
+			blocked,
+			lookup_status,
 			created_at,
 			updated_at
-		) values(?, ?, ?, ?, ?)
-		ON CONFLICT(ip_address) DO UPDATE SET
-			response_code = ?,
-			updated_at = ?
-	`
+		) values(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(%[2]s) DO UPDATE SET
+			response_code = excluded.response_code,
+			listed = excluded.listed,
+			categories = excluded.categories,
+			severity = excluded.severity,
+			reason = excluded.reason,
+			verdict = excluded.verdict,
+			score = excluded.score,
+			blocked = excluded.blocked,
+			lookup_status = excluded.lookup_status,
+			updated_at = excluded.updated_at
+		WHERE %[3]s
+	`, t.table, t.keyColumn, replaceable(t.table))
+
+	listingSQLStmt := fmt.Sprintf(`
+		INSERT INTO %[1]s(
+			%[2]s,
+			blocklist_domain,
+			allowlist,
+			listed,
+			lookup_status,
+			response_code,
+			reason,
+			updated_at
+		) values(?, ?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(%[2]s, blocklist_domain) DO UPDATE SET
+			allowlist = excluded.allowlist,
+			listed = excluded.listed,
+			lookup_status = excluded.lookup_status,
+			response_code = excluded.response_code,
+			reason = excluded.reason,
+			updated_at = excluded.updated_at
+		WHERE %[3]s
+	`, t.listingTable, t.keyColumn, replaceable(t.listingTable))
 
-	stmt, err := db.db.Prepare(sqlStmt)
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error for %s %s, error: %s", t.keyName, record.IPAddress, err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	stmt, err := tx.Prepare(sqlStmt)
 	if err != nil {
 		panic(err)
 	}
 
 	defer stmt.Close()
 
+	listingStmt, err := tx.Prepare(listingSQLStmt)
+	if err != nil {
+		panic(err)
+	}
+
+	defer listingStmt.Close()
+
+	categories := strings.Join(record.Categories, ",")
+	severity := record.Severity
+	if !severity.IsValid() {
+		severity = model.SeverityNone
+	}
+	verdict := record.Verdict
+	if !verdict.IsValid() {
+		verdict = model.VerdictNeutral
+	}
+	lookupStatus := validLookupStatus(record.LookupStatus, record.Listed)
+
 	currentTime := time.Now().Format(time.RFC3339)
-	_, err = stmt.Exec(record.UUID, record.IPAddress, record.ResponseCode, currentTime, currentTime, record.ResponseCode, currentTime)
+
+	change := recordChange{record.ResponseCode, record.Listed, categories, severity, record.Score, record.Blocked}
+	changed := false
+	if lookupStatus == model.LookupStatusListed || lookupStatus == model.LookupStatusNotListed {
+		changed, err = db.resultChanged(tx, t, record.IPAddress, change)
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("unexpected database select error for %s %s, error: %s", t.keyName, record.IPAddress, err)
+			log.Printf("%s\n", err)
+			return err
+		}
+	}
+
+	_, err = stmt.Exec(record.UUID, record.IPAddress, record.ResponseCode, record.Listed, categories, severity, record.Reason, verdict, record.Score, record.Blocked, lookupStatus, currentTime, currentTime)
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("unexpected database insert error for %s %s, error: %s", t.keyName, record.IPAddress, err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	for _, listing := range record.Listings {
+		_, err = listingStmt.Exec(record.IPAddress, listing.BlocklistDomain, listing.Allowlist, listing.Listed, validLookupStatus(listing.LookupStatus, listing.Listed), listing.ResponseCode, listing.Reason, currentTime)
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("unexpected database insert error for %s %s, blocklist domain %s, error: %s", t.keyName, record.IPAddress, listing.BlocklistDomain, err)
+			log.Printf("%s\n", err)
+			return err
+		}
+	}
+
+	if changed {
+		_, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO %[1]s(%[2]s, changed_at, response_code, listed, categories, severity, score, blocked) values(?, ?, ?, ?, ?, ?, ?, ?)
+		`, t.historyTable, t.keyColumn), record.IPAddress, currentTime, change.responseCode, change.listed, change.categories, change.severity, change.score, change.blocked)
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("unexpected database insert error for history of %s %s, error: %s", t.keyName, record.IPAddress, err)
+			log.Printf("%s\n", err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
 	if err != nil {
-		err = fmt.Errorf("unexpected database insert error for ip address %s, error: %s", record.IPAddress, err)
+		err = fmt.Errorf("unexpected database commit error for %s %s, error: %s", t.keyName, record.IPAddress, err)
 		log.Printf("%s\n", err)
 	}
 
 	return err
 }
 
-//SelectRecord function
-func (db *Database) SelectRecord(ipAddress string) (*model.DNSBlockListRecord, error) {
-	sqlStmt := `
+//recordChange type - the columns of a record making up its result, which are copied
+//to its history when they change
+type recordChange struct {
+	responseCode string
+	listed       bool
+	categories   string
+	severity     model.Severity
+	score        float64
+	blocked      bool
+}
+
+//resultChanged reports whether the result stored for key differs from change, or no
+//result is stored yet
+func (db *Database) resultChanged(tx *sql.Tx, t recordTable, key string, change recordChange) (bool, error) {
+	var stored recordChange
+	var responseCode sql.NullString
+
+	err := tx.QueryRow(fmt.Sprintf(`
+		SELECT response_code, listed, categories, severity, score, blocked FROM %[1]s
+		WHERE %[2]s = ? AND lookup_status IN ('%[3]s', '%[4]s')
+	`, t.table, t.keyColumn, model.LookupStatusListed, model.LookupStatusNotListed), key).Scan(
+		&responseCode, &stored.listed, &stored.categories, &stored.severity, &stored.score, &stored.blocked)
+	switch {
+	case err == sql.ErrNoRows:
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+	stored.responseCode = responseCode.String
+
+	return stored != change, nil
+}
+
+//replaceable returns the condition under which a row of table is replaced on conflict:
+//either the new row holds a result, or the existing row does not
+func replaceable(table string) string {
+	return fmt.Sprintf("excluded.lookup_status IN ('%[2]s', '%[3]s') OR %[1]s.lookup_status NOT IN ('%[2]s', '%[3]s')",
+		table, model.LookupStatusListed, model.LookupStatusNotListed)
+}
+
+//validLookupStatus returns status, or the status implied by listed for records
+//stored without one
+func validLookupStatus(status model.LookupStatus, listed bool) model.LookupStatus {
+	switch {
+	case status.IsValid():
+		return status
+	case listed:
+		return model.LookupStatusListed
+	default:
+		return model.LookupStatusNotListed
+	}
+}
+
+//selectRecord reads the record for key from the tables of t, returning the key in IPAddress
+func (db *Database) selectRecord(t recordTable, key string) (*model.DNSBlockListRecord, error) {
+	sqlStmt := fmt.Sprintf(`
 		SELECT
 			id,
-			ip_address,
+			%[2]s,
 			response_code,
+			listed,
+			categories,
+			severity,
+			reason,
+			verdict,
+			score,
+			blocked,
+			lookup_status,
 			created_at,
 			updated_at
-		FROM dns_blocklist 
-		WHERE ip_address = ?
-	`
-
-	stmt, err := db.db.Prepare(sqlStmt)
-	if err != nil {
-		panic(err)
-	}
-
-	defer stmt.Close()
+		FROM %[1]s 
+		WHERE %[2]s = ?
+	`, t.table, t.keyColumn)
 
-	_, err = stmt.Exec(ipAddress)
-	if err != nil {
-		log.Printf("unexpected database select exec error for ip address %s, error: %s", ipAddress, err)
-		err := fmt.Errorf("unexpected query failure encountered for ip address %s", ipAddress)
-		return nil, err
-	}
 	var dblRec model.DNSBlockListRecord
+	var categories string
 	var createdAt string
 	var updatedAt string
 
-	err = db.db.QueryRow(sqlStmt, ipAddress).Scan(
+	err := db.db.QueryRow(sqlStmt, key).Scan(
 		&dblRec.UUID,
 		&dblRec.IPAddress,
 		&dblRec.ResponseCode,
+		&dblRec.Listed,
+		&categories,
+		&dblRec.Severity,
+		&dblRec.Reason,
+		&dblRec.Verdict,
+		&dblRec.Score,
+		&dblRec.Blocked,
+		&dblRec.LookupStatus,
 		&createdAt,
 		&updatedAt,
 	)
 
 	switch {
 	case err == sql.ErrNoRows:
-		log.Printf("no record found in database select for ip address %s, error: %s", ipAddress, err)
-		err := fmt.Errorf("blocklist for ip address %s not found", ipAddress)
+		log.Printf("no record found in database select for %s %s, error: %s", t.keyName, key, err)
+		err := fmt.Errorf("blocklist for %s %s not found", t.keyName, key)
 
 		return nil, err
 	case err != nil:
-		log.Printf("unexpected database select error for ip address %s, error: %s", ipAddress, err)
-		err := fmt.Errorf("unexpected query failure encountered for ip address %s", ipAddress)
-		log.Fatalf("query error: %v\n", err)
+		log.Printf("unexpected database select error for %s %s, error: %s", t.keyName, key, err)
+		err := fmt.Errorf("unexpected query failure encountered for %s %s", t.keyName, key)
+		return nil, err
 	default:
 		dblRec.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
 		dblRec.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		dblRec.Categories = []string{}
+		if categories != "" {
+			dblRec.Categories = strings.Split(categories, ",")
+		}
+	}
+
+	dblRec.Listings, err = db.selectListings(t, key)
+	if err != nil {
+		return nil, err
+	}
+
+	dblRec.History, err = db.selectHistory(t, key)
+	if err != nil {
+		return nil, err
 	}
 
 	return &dblRec, nil
 }
+
+func (db *Database) selectListings(t recordTable, key string) ([]*model.BlocklistListing, error) {
+	sqlStmt := fmt.Sprintf(`
+		SELECT
+			blocklist_domain,
+			allowlist,
+			listed,
+			lookup_status,
+			response_code,
+			reason,
+			updated_at
+		FROM %[1]s
+		WHERE %[2]s = ?
+		ORDER BY allowlist, blocklist_domain
+	`, t.listingTable, t.keyColumn)
+
+	rows, err := db.db.Query(sqlStmt, key)
+	if err != nil {
+		log.Printf("unexpected database select error for listings of %s %s, error: %s", t.keyName, key, err)
+		err := fmt.Errorf("unexpected query failure encountered for %s %s", t.keyName, key)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	listings := []*model.BlocklistListing{}
+	for rows.Next() {
+		var listing model.BlocklistListing
+		var updatedAt string
+
+		err = rows.Scan(
+			&listing.BlocklistDomain,
+			&listing.Allowlist,
+			&listing.Listed,
+			&listing.LookupStatus,
+			&listing.ResponseCode,
+			&listing.Reason,
+			&updatedAt,
+		)
+		if err != nil {
+			log.Printf("unexpected database scan error for listings of %s %s, error: %s", t.keyName, key, err)
+			err := fmt.Errorf("unexpected query failure encountered for %s %s", t.keyName, key)
+			return nil, err
+		}
+		listing.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+		listings = append(listings, &listing)
+	}
+
+	return listings, rows.Err()
+}
+
+func (db *Database) selectHistory(t recordTable, key string) ([]*model.RecordChange, error) {
+	sqlStmt := fmt.Sprintf(`
+		SELECT
+			changed_at,
+			response_code,
+			listed,
+			categories,
+			severity,
+			score,
+			blocked
+		FROM %[1]s
+		WHERE %[2]s = ?
+		ORDER BY seq DESC
+	`, t.historyTable, t.keyColumn)
+
+	rows, err := db.db.Query(sqlStmt, key)
+	if err != nil {
+		log.Printf("unexpected database select error for history of %s %s, error: %s", t.keyName, key, err)
+		err := fmt.Errorf("unexpected query failure encountered for %s %s", t.keyName, key)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	history := []*model.RecordChange{}
+	for rows.Next() {
+		var change model.RecordChange
+		var changedAt string
+		var responseCode sql.NullString
+		var categories string
+
+		err = rows.Scan(
+			&changedAt,
+			&responseCode,
+			&change.Listed,
+			&categories,
+			&change.Severity,
+			&change.Score,
+			&change.Blocked,
+		)
+		if err != nil {
+			log.Printf("unexpected database scan error for history of %s %s, error: %s", t.keyName, key, err)
+			err := fmt.Errorf("unexpected query failure encountered for %s %s", t.keyName, key)
+			return nil, err
+		}
+		change.ChangedAt, _ = time.Parse(time.RFC3339, changedAt)
+		change.ResponseCode = responseCode.String
+		change.Categories = []string{}
+		if categories != "" {
+			change.Categories = strings.Split(categories, ",")
+		}
+		history = append(history, &change)
+	}
+
+	return history, rows.Err()
+}
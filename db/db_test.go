@@ -1,6 +1,8 @@
 package db
 
 import (
+	"database/sql"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
@@ -62,4 +64,713 @@ func TestDb(t *testing.T) {
 		db.CloseDatabase()
 		os.Remove(config.Database.DbPath)
 	})
+
+	t.Run("select_record_success_listings", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		record := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.2",
+			ResponseCode: "127.0.0.2",
+			Verdict:      model.VerdictBlock,
+			Listings: []*model.BlocklistListing{
+				{BlocklistDomain: "zen.spamhaus.org", Listed: true, ResponseCode: "127.0.0.2"},
+				{BlocklistDomain: "bl.spamcop.net", Listed: false, ResponseCode: "NXDOMAIN"},
+				{BlocklistDomain: "list.dnswl.org", Allowlist: true, Listed: false, ResponseCode: "NXDOMAIN"},
+			},
+		}
+		err := db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		dblRec, err := db.SelectRecord("127.0.0.2")
+		require.Equal(t, nil, err)
+		require.Equal(t, "127.0.0.2", dblRec.ResponseCode)
+		require.Equal(t, model.VerdictBlock, dblRec.Verdict)
+		require.Len(t, dblRec.Listings, 3)
+		require.Equal(t, "bl.spamcop.net", dblRec.Listings[0].BlocklistDomain)
+		require.Equal(t, false, dblRec.Listings[0].Listed)
+		require.Equal(t, "zen.spamhaus.org", dblRec.Listings[1].BlocklistDomain)
+		require.Equal(t, true, dblRec.Listings[1].Listed)
+		require.Equal(t, "127.0.0.2", dblRec.Listings[1].ResponseCode)
+		require.Nil(t, dblRec.Reason)
+		require.Nil(t, dblRec.Listings[1].Reason)
+		require.Equal(t, "list.dnswl.org", dblRec.Listings[2].BlocklistDomain)
+		require.Equal(t, true, dblRec.Listings[2].Allowlist)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_listing_success", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		reason := "Listed, see https://www.spamhaus.org/query/ip/127.0.0.2"
+		record := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.2",
+			ResponseCode: "127.0.0.2",
+			Listed:       true,
+			Reason:       &reason,
+		}
+		err := db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		listing, err := db.SelectListing("127.0.0.2")
+		require.Equal(t, nil, err)
+		require.Equal(t, &Listing{Listed: true, ResponseCode: "127.0.0.2", Reason: &reason}, listing)
+
+		//Addresses without a record are not an error
+		listing, err = db.SelectListing("127.0.0.1")
+		require.Equal(t, nil, err)
+		require.Nil(t, listing)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_record_success_categories", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		reason := "https://www.spamhaus.org/query/ip/127.0.0.9"
+		record := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.9",
+			ResponseCode: "127.0.0.2",
+			Listed:       true,
+			Categories:   []string{"SBL", "DROP"},
+			Severity:     model.SeverityCritical,
+			Reason:       &reason,
+			Listings: []*model.BlocklistListing{
+				{BlocklistDomain: "zen.spamhaus.org", Listed: true, ResponseCode: "127.0.0.2", Reason: &reason},
+			},
+		}
+		err := db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		dblRec, err := db.SelectRecord("127.0.0.9")
+		require.Equal(t, nil, err)
+		require.Equal(t, true, dblRec.Listed)
+		require.Equal(t, []string{"SBL", "DROP"}, dblRec.Categories)
+		require.Equal(t, model.SeverityCritical, dblRec.Severity)
+		require.Equal(t, reason, *dblRec.Reason)
+		require.Equal(t, reason, *dblRec.Listings[0].Reason)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_record_success_score", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		record := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.9",
+			ResponseCode: "127.0.0.9",
+			Listed:       true,
+			Score:        3.0,
+			Blocked:      true,
+		}
+		err := db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		dblRec, err := db.SelectRecord("127.0.0.9")
+		require.Equal(t, nil, err)
+		require.Equal(t, 3.0, dblRec.Score)
+		require.Equal(t, true, dblRec.Blocked)
+
+		//A refresh replaces the score
+		record.Score = 0.25
+		record.Blocked = false
+		err = db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		dblRec, err = db.SelectRecord("127.0.0.9")
+		require.Equal(t, nil, err)
+		require.Equal(t, 0.25, dblRec.Score)
+		require.Equal(t, false, dblRec.Blocked)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("upsert_record_success_failed_lookup_keeps_result", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		reason := "127.0.0.2 is listed"
+		record := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.2",
+			ResponseCode: "127.0.0.2",
+			Listed:       true,
+			Reason:       &reason,
+			LookupStatus: model.LookupStatusListed,
+			Listings: []*model.BlocklistListing{
+				{BlocklistDomain: "zen.spamhaus.org", Listed: true, LookupStatus: model.LookupStatusListed, ResponseCode: "127.0.0.2"},
+			},
+		}
+		err := db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		//A failed lookup does not replace the result of the successful one
+		failed := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.2",
+			ResponseCode: "TIMEOUT",
+			LookupStatus: model.LookupStatusTimeout,
+			Listings: []*model.BlocklistListing{
+				{BlocklistDomain: "zen.spamhaus.org", LookupStatus: model.LookupStatusTimeout, ResponseCode: "TIMEOUT"},
+			},
+		}
+		err = db.UpsertRecord(&failed)
+		require.Equal(t, nil, err)
+
+		dblRec, err := db.SelectRecord("127.0.0.2")
+		require.Equal(t, nil, err)
+		require.Equal(t, true, dblRec.Listed)
+		require.Equal(t, model.LookupStatusListed, dblRec.LookupStatus)
+		require.Equal(t, "127.0.0.2", dblRec.ResponseCode)
+		require.Equal(t, model.LookupStatusListed, dblRec.Listings[0].LookupStatus)
+
+		//A failed lookup of an ip address without a result is stored
+		failed.IPAddress = "127.0.0.1"
+		err = db.UpsertRecord(&failed)
+		require.Equal(t, nil, err)
+
+		dblRec, err = db.SelectRecord("127.0.0.1")
+		require.Equal(t, nil, err)
+		require.Equal(t, model.LookupStatusTimeout, dblRec.LookupStatus)
+
+		//A successful lookup replaces the failed one
+		record.IPAddress = "127.0.0.1"
+		record.Listed = false
+		record.LookupStatus = model.LookupStatusNotListed
+		record.Listings = nil
+		err = db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		dblRec, err = db.SelectRecord("127.0.0.1")
+		require.Equal(t, nil, err)
+		require.Equal(t, model.LookupStatusNotListed, dblRec.LookupStatus)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_record_success_history", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		record := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.2",
+			ResponseCode: "NXDOMAIN",
+			LookupStatus: model.LookupStatusNotListed,
+		}
+		err := db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		//Neither an unchanged result nor a failed lookup is added to the history
+		err = db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+		err = db.UpsertRecord(&model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.2",
+			ResponseCode: "TIMEOUT",
+			LookupStatus: model.LookupStatusTimeout,
+		})
+		require.Equal(t, nil, err)
+
+		record.ResponseCode = "127.0.0.2"
+		record.Listed = true
+		record.Categories = []string{"SBL"}
+		record.Severity = model.SeverityHigh
+		record.Score = 1
+		record.Blocked = true
+		record.LookupStatus = model.LookupStatusListed
+		err = db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		dblRec, err := db.SelectRecord("127.0.0.2")
+		require.Equal(t, nil, err)
+		require.Len(t, dblRec.History, 2)
+		require.Equal(t, "127.0.0.2", dblRec.History[0].ResponseCode)
+		require.Equal(t, true, dblRec.History[0].Listed)
+		require.Equal(t, []string{"SBL"}, dblRec.History[0].Categories)
+		require.Equal(t, model.SeverityHigh, dblRec.History[0].Severity)
+		require.Equal(t, true, dblRec.History[0].Blocked)
+		require.Equal(t, "NXDOMAIN", dblRec.History[1].ResponseCode)
+		require.Equal(t, false, dblRec.History[1].Listed)
+		require.Equal(t, []string{}, dblRec.History[1].Categories)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_stale_records_success", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		for _, ipAddress := range []string{"127.0.0.2", "127.0.0.3", "127.0.0.4", "127.0.0.5"} {
+			err := db.UpsertRecord(&model.DNSBlockListRecord{
+				UUID:         uuid.New().String(),
+				IPAddress:    ipAddress,
+				ResponseCode: "NXDOMAIN",
+			})
+			require.Equal(t, nil, err)
+		}
+
+		//Backdate all records but 127.0.0.5, 127.0.0.3 being the least recently updated
+		old := time.Now().Add(-2 * time.Hour)
+		_, err := db.db.Exec("UPDATE dns_blocklist SET updated_at = ? WHERE ip_address IN ('127.0.0.2', '127.0.0.4')", old.Format(time.RFC3339))
+		require.Equal(t, nil, err)
+		_, err = db.db.Exec("UPDATE dns_blocklist SET updated_at = ? WHERE ip_address = '127.0.0.3'", old.Add(-time.Hour).Format(time.RFC3339))
+		require.Equal(t, nil, err)
+
+		before := time.Now().Add(-time.Hour)
+		records, err := db.SelectStaleRecords(before, nil, 2)
+		require.Equal(t, nil, err)
+		require.Len(t, records, 2)
+		require.Equal(t, "127.0.0.3", records[0].IPAddress)
+		require.Equal(t, "127.0.0.2", records[1].IPAddress)
+
+		records, err = db.SelectStaleRecords(before, &records[1], 2)
+		require.Equal(t, nil, err)
+		require.Len(t, records, 1)
+		require.Equal(t, "127.0.0.4", records[0].IPAddress)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("new_database_success_migrate_columns", func(t *testing.T) {
+
+		sqlDb, err := sql.Open(config.Database.DbType, config.Database.DbPath)
+		require.Equal(t, nil, err)
+		_, err = sqlDb.Exec(`
+			CREATE TABLE dns_blocklist (
+				ip_address TEXT PRIMARY KEY NOT NULL, 
+				id TEXT NOT NULL, 
+				response_code text,
+				created_at DATETIME CURRENT_TIMESTAMP, 
+				updated_at DATETIME CURRENT_TIMESTAMP
+			);
+		`)
+		require.Equal(t, nil, err)
+		sqlDb.Close()
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		record := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.3",
+			ResponseCode: "127.0.0.3",
+			Listed:       true,
+			Categories:   []string{"CSS"},
+			Severity:     model.SeverityMedium,
+		}
+		err = db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_record_success_canonical_ipv6", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		record := model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "2001:DB8:0:0::0001",
+			ResponseCode: "NXDOMAIN",
+		}
+		err := db.UpsertRecord(&record)
+		require.Equal(t, nil, err)
+		require.Equal(t, "2001:db8::1", record.IPAddress)
+
+		dblRec, err := db.SelectRecord("2001:db8::0:1")
+		require.Equal(t, nil, err)
+		require.Equal(t, "2001:db8::1", dblRec.IPAddress)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_domain_record_success", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		record := model.DNSDomainBlockListRecord{
+			UUID:         uuid.New().String(),
+			Domain:       "Example.COM.",
+			ResponseCode: "127.0.1.2",
+			Listed:       true,
+			Categories:   []string{"DBL-SPAM"},
+			Severity:     model.SeverityHigh,
+			Score:        1.0,
+			Blocked:      true,
+			Listings: []*model.BlocklistListing{
+				{BlocklistDomain: "dbl.spamhaus.org", Listed: true, ResponseCode: "127.0.1.2"},
+			},
+		}
+		err := db.UpsertDomainRecord(&record)
+		require.Equal(t, nil, err)
+
+		dblRec, err := db.SelectDomainRecord("example.com")
+		require.Equal(t, nil, err)
+		require.Equal(t, "example.com", dblRec.Domain)
+		require.Equal(t, "127.0.1.2", dblRec.ResponseCode)
+		require.Equal(t, []string{"DBL-SPAM"}, dblRec.Categories)
+		require.Equal(t, 1.0, dblRec.Score)
+		require.Equal(t, true, dblRec.Blocked)
+		require.Len(t, dblRec.Listings, 1)
+
+		_, err = db.SelectRecord("example.com")
+		require.EqualError(t, err, "blocklist for ip address example.com not found")
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_domain_record_failure_not_found", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		_, err := db.SelectDomainRecord("example.org")
+		require.EqualError(t, err, "blocklist for domain example.org not found")
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("select_job_success", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		job := model.Job{
+			ID:    uuid.New().String(),
+			Kind:  model.JobKindIPAddress,
+			State: model.JobStateQueued,
+			Items: []*model.JobItem{
+				{Target: "127.0.0.2", State: model.JobItemStatePending},
+				{Target: "127.0.0.1", State: model.JobItemStatePending},
+			},
+		}
+		err := db.InsertJob(&job, "secureworks")
+		require.Equal(t, nil, err)
+
+		owner, err := db.SelectJobOwner(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, "secureworks", owner)
+
+		err = db.UpdateJobState(job.ID, model.JobStateRunning)
+		require.Equal(t, nil, err)
+		err = db.UpdateJobItem(job.ID, 0, model.JobItemStateCompleted, model.LookupStatusListed, false)
+		require.Equal(t, nil, err)
+
+		dbJob, err := db.SelectJob(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobStateRunning, dbJob.State)
+		require.Equal(t, 2, dbJob.Total)
+		require.Equal(t, 1, dbJob.Completed)
+		require.Equal(t, 0, dbJob.Failed)
+		require.Equal(t, "127.0.0.2", dbJob.Items[0].Target)
+		require.Equal(t, model.LookupStatusListed, *dbJob.Items[0].LookupStatus)
+		require.Equal(t, model.JobItemStatePending, dbJob.Items[1].State)
+		require.Nil(t, dbJob.Items[1].LookupStatus)
+
+		//Jobs left unfinished are failed when the service restarts
+		count, err := db.FailUnfinishedJobs()
+		require.Equal(t, nil, err)
+		require.Equal(t, 1, count)
+
+		dbJob, err = db.SelectJob(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobStateFailed, dbJob.State)
+		require.Equal(t, 1, dbJob.Completed)
+		require.Equal(t, 1, dbJob.Failed)
+
+		queued, err := db.QueueJob(job.ID, model.JobPriorityNormal, "secureworks", 10)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, queued)
+
+		err = db.DeleteJob(job.ID)
+		require.Equal(t, nil, err)
+
+		_, err = db.SelectJob(job.ID)
+		require.EqualError(t, err, fmt.Sprintf("job %s not found", job.ID))
+
+		//The job is also deleted from the queue
+		id, err := db.LeaseJob("worker-1", time.Minute)
+		require.Equal(t, nil, err)
+		require.Empty(t, id)
+		_, err = db.SelectJobOwner(job.ID)
+		require.Equal(t, &JobNotFoundError{ID: job.ID}, err)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("cancel_job_success", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		job := model.Job{
+			ID:    uuid.New().String(),
+			Kind:  model.JobKindIPAddress,
+			State: model.JobStateQueued,
+			Items: []*model.JobItem{
+				{Target: "127.0.0.2", State: model.JobItemStatePending},
+				{Target: "127.0.0.1", State: model.JobItemStatePending},
+			},
+		}
+		err := db.InsertJob(&job, "")
+		require.Equal(t, nil, err)
+		queued, err := db.QueueJob(job.ID, model.JobPriorityNormal, "", 10)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, queued)
+
+		err = db.UpdateJobState(job.ID, model.JobStateRunning)
+		require.Equal(t, nil, err)
+		err = db.UpdateJobItem(job.ID, 0, model.JobItemStateCompleted, model.LookupStatusListed, false)
+		require.Equal(t, nil, err)
+
+		cancelled, err := db.CancelJob(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, cancelled)
+
+		//The cancelled job keeps its state, and cannot be cancelled again
+		err = db.UpdateJobState(job.ID, model.JobStateCompleted)
+		require.Equal(t, nil, err)
+		cancelled, err = db.CancelJob(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, false, cancelled)
+
+		dbJob, err := db.SelectJob(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobStateCancelled, dbJob.State)
+		require.Equal(t, 1, dbJob.Completed)
+		require.Equal(t, 1, dbJob.Cancelled)
+		require.Equal(t, model.JobItemStateCancelled, dbJob.Items[1].State)
+
+		//Nor is it failed
+		err = db.FailJob(job.ID)
+		require.Equal(t, nil, err)
+		dbJob, err = db.SelectJob(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobStateCancelled, dbJob.State)
+		require.Equal(t, model.JobItemStateCancelled, dbJob.Items[1].State)
+
+		//The waiting job is taken off the queue
+		removed, err := db.UnqueueJob(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, removed)
+		removed, err = db.UnqueueJob(job.ID)
+		require.Equal(t, nil, err)
+		require.Equal(t, false, removed)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("lease_job_success", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		var jobIDs []string
+		for i := 0; i < 2; i++ {
+			job := model.Job{
+				ID:    uuid.New().String(),
+				Kind:  model.JobKindIPAddress,
+				State: model.JobStateQueued,
+				Items: []*model.JobItem{{Target: "127.0.0.2", State: model.JobItemStatePending}},
+			}
+			err := db.InsertJob(&job, "")
+			require.Equal(t, nil, err)
+			jobIDs = append(jobIDs, job.ID)
+		}
+
+		queued, err := db.QueueJob(jobIDs[0], model.JobPriorityNormal, "", 1)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, queued)
+
+		//The queue is full while the first job waits
+		queued, err = db.QueueJob(jobIDs[1], model.JobPriorityNormal, "", 1)
+		require.Equal(t, nil, err)
+		require.Equal(t, false, queued)
+
+		id, err := db.LeaseJob("worker-1", time.Minute)
+		require.Equal(t, nil, err)
+		require.Equal(t, jobIDs[0], id)
+
+		//Leased jobs no longer count towards the capacity
+		queued, err = db.QueueJob(jobIDs[1], model.JobPriorityNormal, "", 1)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, queued)
+
+		renewed, err := db.RenewJobLease(jobIDs[0], "worker-2", time.Minute)
+		require.Equal(t, nil, err)
+		require.Equal(t, false, renewed)
+		renewed, err = db.RenewJobLease(jobIDs[0], "worker-1", time.Minute)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, renewed)
+
+		//A released job is leased again before the jobs queued after it
+		err = db.ReleaseJob(jobIDs[0], "worker-1")
+		require.Equal(t, nil, err)
+		id, err = db.LeaseJob("worker-2", 0)
+		require.Equal(t, nil, err)
+		require.Equal(t, jobIDs[0], id)
+
+		//So is a job whose lease expired
+		id, err = db.LeaseJob("worker-3", time.Minute)
+		require.Equal(t, nil, err)
+		require.Equal(t, jobIDs[0], id)
+
+		//Queued jobs are left to be resumed when the service restarts
+		count, err := db.FailUnfinishedJobs()
+		require.Equal(t, nil, err)
+		require.Equal(t, 0, count)
+
+		count, err = db.ReleaseJobLeases()
+		require.Equal(t, nil, err)
+		require.Equal(t, 1, count)
+
+		for _, jobID := range jobIDs {
+			err = db.AckJob(jobID)
+			require.Equal(t, nil, err)
+		}
+		id, err = db.LeaseJob("worker-1", time.Minute)
+		require.Equal(t, nil, err)
+		require.Empty(t, id)
+
+		count, err = db.FailUnfinishedJobs()
+		require.Equal(t, nil, err)
+		require.Equal(t, 2, count)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("upsert_dead_letter_success", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		deadLetter := model.DeadLetter{
+			Target:       "127.0.0.2",
+			Kind:         model.JobKindIPAddress,
+			JobID:        uuid.New().String(),
+			Attempts:     3,
+			LookupStatus: model.LookupStatusTimeout,
+		}
+		err := db.UpsertDeadLetter(&deadLetter)
+		require.Equal(t, nil, err)
+
+		//A later failure replaces the outcome of the earlier one
+		deadLetter.JobID = uuid.New().String()
+		deadLetter.LookupStatus = model.LookupStatusError
+		err = db.UpsertDeadLetter(&deadLetter)
+		require.Equal(t, nil, err)
+
+		err = db.UpsertDeadLetter(&model.DeadLetter{
+			Target:       "example.com",
+			Kind:         model.JobKindDomain,
+			JobID:        uuid.New().String(),
+			Attempts:     1,
+			LookupStatus: model.LookupStatusRefused,
+		})
+		require.Equal(t, nil, err)
+
+		deadLetters, err := db.SelectDeadLetters()
+		require.Equal(t, nil, err)
+		require.Len(t, deadLetters, 2)
+		for _, dbDeadLetter := range deadLetters {
+			if dbDeadLetter.Kind == model.JobKindIPAddress {
+				require.Equal(t, deadLetter.JobID, dbDeadLetter.JobID)
+				require.Equal(t, model.LookupStatusError, dbDeadLetter.LookupStatus)
+				require.Equal(t, 3, dbDeadLetter.Attempts)
+			}
+		}
+
+		err = db.DeleteDeadLetters(model.JobKindIPAddress, []string{"127.0.0.2"})
+		require.Equal(t, nil, err)
+
+		deadLetters, err = db.SelectDeadLetters()
+		require.Equal(t, nil, err)
+		require.Len(t, deadLetters, 1)
+		require.Equal(t, "example.com", deadLetters[0].Target)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
+
+	t.Run("lease_job_success_priority_fairness", func(t *testing.T) {
+
+		db = NewDatabase(config)
+		require.NotEqual(t, nil, db)
+
+		queue := func(priority model.JobPriority, owner string) string {
+			job := model.Job{
+				ID:       uuid.New().String(),
+				Kind:     model.JobKindIPAddress,
+				State:    model.JobStateQueued,
+				Priority: priority,
+				Items:    []*model.JobItem{{Target: "127.0.0.2", State: model.JobItemStatePending}},
+			}
+			err := db.InsertJob(&job, owner)
+			require.Equal(t, nil, err)
+			queued, err := db.QueueJob(job.ID, priority, owner, 10)
+			require.Equal(t, nil, err)
+			require.Equal(t, true, queued)
+			return job.ID
+		}
+
+		a1 := queue(model.JobPriorityNormal, "a")
+		a2 := queue(model.JobPriorityNormal, "a")
+		b1 := queue(model.JobPriorityNormal, "b")
+		c1 := queue(model.JobPriorityLow, "c")
+		a3 := queue(model.JobPriorityHigh, "a")
+
+		dbJob, err := db.SelectJob(a3)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobPriorityHigh, dbJob.Priority)
+
+		depth, err := db.QueueDepth()
+		require.Equal(t, nil, err)
+		require.Equal(t, map[model.JobPriority]int{model.JobPriorityHigh: 1, model.JobPriorityNormal: 3, model.JobPriorityLow: 1}, depth)
+
+		//Jobs are leased by priority, the owners taking turns
+		for _, expected := range []string{a3, a1, b1, a2, c1} {
+			id, err := db.LeaseJob("worker-1", time.Minute)
+			require.Equal(t, nil, err)
+			require.Equal(t, expected, id)
+		}
+
+		depth, err = db.QueueDepth()
+		require.Equal(t, nil, err)
+		require.Empty(t, depth)
+
+		db.CloseDatabase()
+		os.Remove(config.Database.DbPath)
+	})
 }
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/egreen64/codingchallenge/graph/model"
+)
+
+//UpsertDeadLetter function - adds the target whose lookup failed to the dead letters,
+//replacing the outcome of an earlier failure while keeping when it was first added
+func (db *Database) UpsertDeadLetter(deadLetter *model.DeadLetter) error {
+	currentTime := time.Now().Format(time.RFC3339)
+
+	_, err := db.db.Exec(`
+		INSERT INTO dead_letter(kind, target, job_id, attempts, lookup_status, created_at, updated_at) values(?, ?, ?, ?, ?, ?, ?)
+		ON CONFLICT(kind, target) DO UPDATE SET
+			job_id = excluded.job_id,
+			attempts = excluded.attempts,
+			lookup_status = excluded.lookup_status,
+			updated_at = excluded.updated_at
+	`, deadLetter.Kind, deadLetter.Target, deadLetter.JobID, deadLetter.Attempts, deadLetter.LookupStatus, currentTime, currentTime)
+	if err != nil {
+		err = fmt.Errorf("unexpected database upsert error for dead letter %s, error: %s", deadLetter.Target, err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
+
+//SelectDeadLetters function - reads the dead letters, most recently failed first
+func (db *Database) SelectDeadLetters() ([]*model.DeadLetter, error) {
+	rows, err := db.db.Query(`
+		SELECT kind, target, job_id, attempts, lookup_status, created_at, updated_at FROM dead_letter
+		ORDER BY updated_at DESC, kind, target
+	`)
+	if err != nil {
+		log.Printf("unexpected database select error for dead letters, error: %s", err)
+		err := fmt.Errorf("unexpected query failure encountered for dead letters")
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deadLetters := []*model.DeadLetter{}
+	for rows.Next() {
+		var deadLetter model.DeadLetter
+		var createdAt string
+		var updatedAt string
+
+		err = rows.Scan(&deadLetter.Kind, &deadLetter.Target, &deadLetter.JobID, &deadLetter.Attempts, &deadLetter.LookupStatus, &createdAt, &updatedAt)
+		if err != nil {
+			log.Printf("unexpected database scan error for dead letters, error: %s", err)
+			err := fmt.Errorf("unexpected query failure encountered for dead letters")
+			return nil, err
+		}
+		deadLetter.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+		deadLetter.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+
+		deadLetters = append(deadLetters, &deadLetter)
+	}
+
+	return deadLetters, rows.Err()
+}
+
+//DeleteDeadLetters function - removes the dead letters of the targets of kind
+func (db *Database) DeleteDeadLetters(kind model.JobKind, targets []string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error for dead letters, error: %s", err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	for _, target := range targets {
+		_, err = tx.Exec("DELETE FROM dead_letter WHERE kind = ? AND target = ?", kind, target)
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("unexpected database delete error for dead letter %s, error: %s", target, err)
+			log.Printf("%s\n", err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unexpected database commit error for dead letters, error: %s", err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
@@ -0,0 +1,340 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/egreen64/codingchallenge/graph/model"
+)
+
+//JobNotFoundError type - returned when no job has the id
+type JobNotFoundError struct {
+	ID string
+}
+
+func (e *JobNotFoundError) Error() string {
+	return fmt.Sprintf("job %s not found", e.ID)
+}
+
+//InsertJob function - inserts the job enqueued by owner together with its items
+func (db *Database) InsertJob(job *model.Job, owner string) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error for job %s, error: %s", job.ID, err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	currentTime := time.Now().Format(time.RFC3339)
+	_, err = tx.Exec(`
+		INSERT INTO job(id, kind, state, priority, owner, created_at, updated_at) values(?, ?, ?, ?, ?, ?, ?)
+	`, job.ID, job.Kind, job.State, validJobPriority(job.Priority), owner, currentTime, currentTime)
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("unexpected database insert error for job %s, error: %s", job.ID, err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	itemStmt, err := tx.Prepare(`
+		INSERT INTO job_item(job_id, position, target, state, lookup_status, updated_at) values(?, ?, ?, ?, ?, ?)
+	`)
+	if err != nil {
+		panic(err)
+	}
+
+	defer itemStmt.Close()
+
+	for i, item := range job.Items {
+		_, err = itemStmt.Exec(job.ID, i, item.Target, item.State, item.LookupStatus, currentTime)
+		if err != nil {
+			tx.Rollback()
+			err = fmt.Errorf("unexpected database insert error for job %s, target %s, error: %s", job.ID, item.Target, err)
+			log.Printf("%s\n", err)
+			return err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unexpected database commit error for job %s, error: %s", job.ID, err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
+
+//DeleteJob function - deletes the job together with its items
+func (db *Database) DeleteJob(id string) error {
+	//The items of the job, and its entry on the queue, are deleted by the foreign keys
+	_, err := db.db.Exec("DELETE FROM job WHERE id = ?", id)
+	if err != nil {
+		err = fmt.Errorf("unexpected database delete error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
+
+//UpdateJobState function - a cancelled job keeps its state
+func (db *Database) UpdateJobState(id string, state model.JobState) error {
+	_, err := db.db.Exec(`
+		UPDATE job SET state = ?, updated_at = ? WHERE id = ? AND state != ?
+	`, state, time.Now().Format(time.RFC3339), id, model.JobStateCancelled)
+	if err != nil {
+		err = fmt.Errorf("unexpected database update error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
+
+//UpdateJobItem function - records the outcome of looking up the item at position of the job,
+//cached being set if the outcome was taken from a recently stored record instead
+func (db *Database) UpdateJobItem(id string, position int, state model.JobItemState, lookupStatus model.LookupStatus, cached bool) error {
+	currentTime := time.Now().Format(time.RFC3339)
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE job_item SET state = ?, lookup_status = ?, cached = ?, updated_at = ? WHERE job_id = ? AND position = ?
+	`, state, lookupStatus, cached, currentTime, id, position)
+	if err == nil {
+		_, err = tx.Exec("UPDATE job SET updated_at = ? WHERE id = ?", currentTime, id)
+	}
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("unexpected database update error for job %s, item %d, error: %s", id, position, err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unexpected database commit error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
+
+//CancelJob function - marks the job cancelled if it is queued or running, together
+//with the pending items of a cancelled job. Returns false if the job was not queued
+//or running, the items of a job already cancelled still being marked.
+func (db *Database) CancelJob(id string) (bool, error) {
+	currentTime := time.Now().Format(time.RFC3339)
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return false, err
+	}
+
+	result, err := tx.Exec(`
+		UPDATE job SET state = ?, updated_at = ? WHERE id = ? AND state IN (?, ?)
+	`, model.JobStateCancelled, currentTime, id, model.JobStateQueued, model.JobStateRunning)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE job_item SET state = ?, updated_at = ?
+			WHERE job_id = ? AND state = ? AND job_id IN (SELECT id FROM job WHERE state = ?)
+		`, model.JobItemStateCancelled, currentTime, id, model.JobItemStatePending, model.JobStateCancelled)
+	}
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("unexpected database update error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unexpected database commit error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return false, err
+	}
+
+	count, _ := result.RowsAffected()
+	return count > 0, nil
+}
+
+//FailJob function - marks the job failed if it is queued or running, together with
+//its pending items
+func (db *Database) FailJob(id string) error {
+	currentTime := time.Now().Format(time.RFC3339)
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE job_item SET state = ?, updated_at = ?
+		WHERE job_id = ? AND state = ? AND job_id IN (SELECT id FROM job WHERE state IN (?, ?))
+	`, model.JobItemStateFailed, currentTime, id, model.JobItemStatePending, model.JobStateQueued, model.JobStateRunning)
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE job SET state = ?, updated_at = ? WHERE id = ? AND state IN (?, ?)
+		`, model.JobStateFailed, currentTime, id, model.JobStateQueued, model.JobStateRunning)
+	}
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("unexpected database update error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unexpected database commit error for job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
+
+//FailUnfinishedJobs function - marks the jobs that were queued or running when the
+//service last stopped as failed, together with their pending items. Jobs held by a
+//durable job queue are left to be resumed. Returns the number of jobs marked.
+func (db *Database) FailUnfinishedJobs() (int, error) {
+	currentTime := time.Now().Format(time.RFC3339)
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error for unfinished jobs, error: %s", err)
+		log.Printf("%s\n", err)
+		return 0, err
+	}
+
+	_, err = tx.Exec(`
+		UPDATE job_item SET state = ?, updated_at = ?
+		WHERE state = ? AND job_id IN (SELECT id FROM job WHERE state IN (?, ?) AND id NOT IN (SELECT job_id FROM job_queue))
+	`, model.JobItemStateFailed, currentTime, model.JobItemStatePending, model.JobStateQueued, model.JobStateRunning)
+
+	var result sql.Result
+	if err == nil {
+		result, err = tx.Exec(`
+			UPDATE job SET state = ?, updated_at = ? WHERE state IN (?, ?) AND id NOT IN (SELECT job_id FROM job_queue)
+		`, model.JobStateFailed, currentTime, model.JobStateQueued, model.JobStateRunning)
+	}
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("unexpected database update error for unfinished jobs, error: %s", err)
+		log.Printf("%s\n", err)
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unexpected database commit error for unfinished jobs, error: %s", err)
+		log.Printf("%s\n", err)
+		return 0, err
+	}
+
+	count, _ := result.RowsAffected()
+	return int(count), nil
+}
+
+//SelectJobOwner function - reads the owner who enqueued the job
+func (db *Database) SelectJobOwner(id string) (string, error) {
+	var owner string
+
+	err := db.db.QueryRow("SELECT owner FROM job WHERE id = ?", id).Scan(&owner)
+	switch {
+	case err == sql.ErrNoRows:
+		log.Printf("no record found in database select for owner of job %s, error: %s", id, err)
+		return "", &JobNotFoundError{ID: id}
+	case err != nil:
+		log.Printf("unexpected database select error for owner of job %s, error: %s", id, err)
+		err := fmt.Errorf("unexpected query failure encountered for job %s", id)
+		return "", err
+	}
+
+	return owner, nil
+}
+
+//SelectJob function - reads the job together with its items, counting the items in
+//each state
+func (db *Database) SelectJob(id string) (*model.Job, error) {
+	var job model.Job
+	var createdAt string
+	var updatedAt string
+
+	err := db.db.QueryRow(`
+		SELECT id, kind, state, priority, created_at, updated_at FROM job WHERE id = ?
+	`, id).Scan(&job.ID, &job.Kind, &job.State, &job.Priority, &createdAt, &updatedAt)
+
+	switch {
+	case err == sql.ErrNoRows:
+		log.Printf("no record found in database select for job %s, error: %s", id, err)
+		return nil, &JobNotFoundError{ID: id}
+	case err != nil:
+		log.Printf("unexpected database select error for job %s, error: %s", id, err)
+		err := fmt.Errorf("unexpected query failure encountered for job %s", id)
+		return nil, err
+	}
+	job.CreatedAt, _ = time.Parse(time.RFC3339, createdAt)
+	job.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+
+	rows, err := db.db.Query(`
+		SELECT target, state, lookup_status, cached, updated_at FROM job_item WHERE job_id = ? ORDER BY position
+	`, id)
+	if err != nil {
+		log.Printf("unexpected database select error for items of job %s, error: %s", id, err)
+		err := fmt.Errorf("unexpected query failure encountered for job %s", id)
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	job.Items = []*model.JobItem{}
+	for rows.Next() {
+		var item model.JobItem
+		var lookupStatus sql.NullString
+
+		err = rows.Scan(&item.Target, &item.State, &lookupStatus, &item.Cached, &updatedAt)
+		if err != nil {
+			log.Printf("unexpected database scan error for items of job %s, error: %s", id, err)
+			err := fmt.Errorf("unexpected query failure encountered for job %s", id)
+			return nil, err
+		}
+		if lookupStatus.Valid {
+			status := model.LookupStatus(lookupStatus.String)
+			item.LookupStatus = &status
+		}
+		item.UpdatedAt, _ = time.Parse(time.RFC3339, updatedAt)
+
+		job.Total++
+		switch item.State {
+		case model.JobItemStateCompleted:
+			job.Completed++
+		case model.JobItemStateFailed:
+			job.Failed++
+		case model.JobItemStateCancelled:
+			job.Cancelled++
+		}
+		if item.Cached {
+			job.Cached++
+		}
+		job.Items = append(job.Items, &item)
+	}
+
+	return &job, rows.Err()
+}
+
+//validJobPriority returns priority, or NORMAL for jobs enqueued without one
+func validJobPriority(priority model.JobPriority) model.JobPriority {
+	if priority.IsValid() {
+		return priority
+	}
+	return model.JobPriorityNormal
+}
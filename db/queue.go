@@ -0,0 +1,227 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/egreen64/codingchallenge/graph/model"
+)
+
+//waiting is the condition selecting the rows of job_queue not leased by a worker
+const waiting = "(lease_expires_at IS NULL OR lease_expires_at <= ?)"
+
+//priorityRank orders the job priorities, from HIGH to LOW
+func priorityRank(priority model.JobPriority) int {
+	for rank, p := range model.AllJobPriority {
+		if p == priority {
+			return rank
+		}
+	}
+	return priorityRank(model.JobPriorityNormal)
+}
+
+//The job_queue table holds the ids of the jobs waiting to be processed by a durable
+//job queue, in the order they were queued. A worker takes a job by leasing it until
+//lease_expires_at, in milliseconds since the epoch, and removes it once the job has
+//been processed. A job whose lease expires, because its worker stopped, is taken
+//again by the next worker. Waiting jobs are taken in priority order, and jobs of the
+//same priority from each owner in turn: each job is given the turn after the previous
+//job of its owner, but no earlier than the first turn still waiting, and the jobs are
+//taken in the order of their turns.
+
+//QueueJob function - appends the job of the priority enqueued by owner to the queue
+//unless capacity jobs are already waiting, returning false if the queue is full
+func (db *Database) QueueJob(id string, priority model.JobPriority, owner string, capacity int) (bool, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error for queued job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return false, err
+	}
+
+	var count int
+	err = tx.QueryRow("SELECT COUNT(*) FROM job_queue WHERE "+waiting, nowMillis()).Scan(&count)
+	if err == nil && count >= capacity {
+		tx.Rollback()
+		return false, nil
+	}
+	if err == nil {
+		rank := priorityRank(priority)
+		_, err = tx.Exec(`
+			INSERT INTO job_queue(job_id, priority, owner, turn) values(?, ?, ?, MAX(
+				COALESCE((SELECT MAX(turn) + 1 FROM job_queue WHERE priority = ? AND owner = ?), 0),
+				COALESCE((SELECT MIN(turn) FROM job_queue WHERE priority = ? AND `+waiting+`), 0)
+			))
+		`, id, rank, owner, rank, owner, rank, nowMillis())
+	}
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("unexpected database insert error for queued job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return false, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unexpected database commit error for queued job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+//LeaseJob function - leases the next job among those not leased, or whose lease
+//expired, to owner for the lease duration. The next job is the one with the earliest
+//turn of the highest priority waiting, so that an owner queuing many jobs does not
+//hold up the others. Returns an empty id if there is no such job.
+func (db *Database) LeaseJob(owner string, lease time.Duration) (string, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		err = fmt.Errorf("unexpected database transaction error leasing job, error: %s", err)
+		log.Printf("%s\n", err)
+		return "", err
+	}
+
+	now := nowMillis()
+
+	var seq int64
+	var id string
+	err = tx.QueryRow(`
+		SELECT seq, job_id FROM job_queue
+		WHERE `+waiting+`
+		ORDER BY priority, turn, seq
+		LIMIT 1
+	`, now).Scan(&seq, &id)
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return "", nil
+	}
+	if err == nil {
+		_, err = tx.Exec(`
+			UPDATE job_queue SET lease_owner = ?, lease_expires_at = ?, attempts = attempts + 1 WHERE seq = ?
+		`, owner, now+lease.Milliseconds(), seq)
+	}
+	if err != nil {
+		tx.Rollback()
+		err = fmt.Errorf("unexpected database update error leasing job, error: %s", err)
+		log.Printf("%s\n", err)
+		return "", err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		err = fmt.Errorf("unexpected database commit error leasing job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return "", err
+	}
+
+	return id, nil
+}
+
+//RenewJobLease function - extends the lease owner holds on the job by the lease
+//duration. Returns false if owner no longer holds the lease.
+func (db *Database) RenewJobLease(id string, owner string, lease time.Duration) (bool, error) {
+	result, err := db.db.Exec(`
+		UPDATE job_queue SET lease_expires_at = ? WHERE job_id = ? AND lease_owner = ?
+	`, nowMillis()+lease.Milliseconds(), id, owner)
+	if err != nil {
+		err = fmt.Errorf("unexpected database update error renewing lease of job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return false, err
+	}
+
+	count, _ := result.RowsAffected()
+	return count > 0, nil
+}
+
+//ReleaseJob function - returns the job leased by owner to the queue, so that it is
+//taken again without waiting for the lease to expire
+func (db *Database) ReleaseJob(id string, owner string) error {
+	_, err := db.db.Exec(`
+		UPDATE job_queue SET lease_owner = NULL, lease_expires_at = NULL WHERE job_id = ? AND lease_owner = ?
+	`, id, owner)
+	if err != nil {
+		err = fmt.Errorf("unexpected database update error releasing job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
+
+//ReleaseJobLeases function - returns every leased job to the queue. Called at startup,
+//when the leases can only be held by workers of the previous run, as the database is
+//used by a single instance of the service. Returns the number of jobs released.
+func (db *Database) ReleaseJobLeases() (int, error) {
+	result, err := db.db.Exec(`
+		UPDATE job_queue SET lease_owner = NULL, lease_expires_at = NULL WHERE lease_owner IS NOT NULL
+	`)
+	if err != nil {
+		err = fmt.Errorf("unexpected database update error releasing job leases, error: %s", err)
+		log.Printf("%s\n", err)
+		return 0, err
+	}
+
+	count, _ := result.RowsAffected()
+	return int(count), nil
+}
+
+//AckJob function - removes the processed job from the queue
+func (db *Database) AckJob(id string) error {
+	_, err := db.db.Exec("DELETE FROM job_queue WHERE job_id = ?", id)
+	if err != nil {
+		err = fmt.Errorf("unexpected database delete error for queued job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+	}
+
+	return err
+}
+
+//UnqueueJob function - removes the job from the queue unless a worker holds its
+//lease, returning false if the job was not waiting
+func (db *Database) UnqueueJob(id string) (bool, error) {
+	result, err := db.db.Exec("DELETE FROM job_queue WHERE job_id = ? AND "+waiting, id, nowMillis())
+	if err != nil {
+		err = fmt.Errorf("unexpected database delete error for queued job %s, error: %s", id, err)
+		log.Printf("%s\n", err)
+		return false, err
+	}
+
+	count, _ := result.RowsAffected()
+	return count > 0, nil
+}
+
+//QueueDepth function - counts the jobs waiting in the queue, by priority
+func (db *Database) QueueDepth() (map[model.JobPriority]int, error) {
+	rows, err := db.db.Query("SELECT priority, COUNT(*) FROM job_queue WHERE "+waiting+" GROUP BY priority", nowMillis())
+	if err != nil {
+		log.Printf("unexpected database select error for queue depth, error: %s", err)
+		err := fmt.Errorf("unexpected query failure encountered for queue depth")
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	depth := map[model.JobPriority]int{}
+	for rows.Next() {
+		var rank, count int
+
+		err = rows.Scan(&rank, &count)
+		if err != nil {
+			log.Printf("unexpected database scan error for queue depth, error: %s", err)
+			err := fmt.Errorf("unexpected query failure encountered for queue depth")
+			return nil, err
+		}
+		if rank >= 0 && rank < len(model.AllJobPriority) {
+			depth[model.AllJobPriority[rank]] += count
+		}
+	}
+
+	return depth, rows.Err()
+}
+
+func nowMillis() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
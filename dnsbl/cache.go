@@ -0,0 +1,148 @@
+package dnsbl
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+//CacheStats type - counters of a CachingResolver
+type CacheStats struct {
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Entries  int    `json:"entries"`
+	Capacity int    `json:"capacity"`
+}
+
+//cacheEntry type - answer to a single query and when it expires
+type cacheEntry struct {
+	key     string
+	records []string
+	expires time.Time
+}
+
+//CachingResolver type - Resolver keeping the answers of a TTLResolver for as long
+//as their TTL allows. Positive answers are kept for the TTL of their records and
+//negative answers for the negative caching TTL of the zone. Failed queries are
+//not cached. When full, the least recently used answer is evicted.
+type CachingResolver struct {
+	resolver TTLResolver
+	size     int
+	now      func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*list.Element
+	lru     *list.List
+	hits    uint64
+	misses  uint64
+}
+
+//NewCachingResolver function - Create a cache holding up to size answers of resolver
+func NewCachingResolver(resolver TTLResolver, size int) *CachingResolver {
+	return &CachingResolver{
+		resolver: resolver,
+		size:     size,
+		now:      time.Now,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+}
+
+//LookupA function
+func (c *CachingResolver) LookupA(ctx context.Context, host string) ([]string, error) {
+	addrs, _, err := c.LookupATTL(ctx, host)
+	return addrs, err
+}
+
+//LookupTXT function
+func (c *CachingResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	txts, _, err := c.LookupTXTTTL(ctx, host)
+	return txts, err
+}
+
+//LookupATTL function - the TTL of a cached answer is the time it has left in the cache
+func (c *CachingResolver) LookupATTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	return c.lookup(ctx, "A "+host, host, c.resolver.LookupATTL)
+}
+
+//LookupTXTTTL function - the TTL of a cached answer is the time it has left in the cache
+func (c *CachingResolver) LookupTXTTTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	return c.lookup(ctx, "TXT "+host, host, c.resolver.LookupTXTTTL)
+}
+
+//Stats function
+func (c *CachingResolver) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:     c.hits,
+		Misses:   c.misses,
+		Entries:  c.lru.Len(),
+		Capacity: c.size,
+	}
+}
+
+func (c *CachingResolver) lookup(ctx context.Context, key string, host string, lookup func(context.Context, string) ([]string, time.Duration, error)) ([]string, time.Duration, error) {
+	if records, ttl, ok := c.get(key); ok {
+		return records, ttl, nil
+	}
+
+	records, ttl, err := lookup(ctx, host)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	c.put(key, records, ttl)
+
+	return records, ttl, nil
+}
+
+//get returns the unexpired answer for key, counting the hit or miss
+func (c *CachingResolver) get(key string) ([]string, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		if ttl := entry.expires.Sub(c.now()); ttl > 0 {
+			c.lru.MoveToFront(elem)
+			c.hits++
+			return entry.records, ttl, true
+		}
+		c.remove(elem)
+	}
+
+	c.misses++
+	return nil, 0, false
+}
+
+//put stores the answer for key, evicting the least recently used answers to make room
+func (c *CachingResolver) put(key string, records []string, ttl time.Duration) {
+	if ttl <= 0 || c.size <= 0 {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+
+	for c.lru.Len() >= c.size {
+		c.remove(c.lru.Back())
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		records: records,
+		expires: c.now().Add(ttl),
+	})
+}
+
+func (c *CachingResolver) remove(elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, elem.Value.(*cacheEntry).key)
+}
@@ -0,0 +1,188 @@
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+const resolvConfPath = "/etc/resolv.conf"
+
+//TTLResolver interface - Resolver that also reports how long each answer may be
+//cached. For hosts that do not exist the TTL is the negative caching TTL of the
+//zone, taken from its SOA record.
+type TTLResolver interface {
+	Resolver
+
+	//LookupATTL returns the A record addresses for host and their TTL
+	LookupATTL(ctx context.Context, host string) ([]string, time.Duration, error)
+
+	//LookupTXTTTL returns the TXT records for host and their TTL
+	LookupTXTTTL(ctx context.Context, host string) ([]string, time.Duration, error)
+}
+
+//QueryError type - query answered with an error response code such as SERVFAIL or REFUSED
+type QueryError struct {
+	Host  string
+	Qtype uint16
+	Rcode int
+}
+
+//Error function
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s query for %s failed: %s", dns.TypeToString[e.Qtype], e.Host, dns.RcodeToString[e.Rcode])
+}
+
+//ClientResolver type - TTLResolver sending queries directly to recursive nameservers
+type ClientResolver struct {
+	timeout     time.Duration
+	nameservers []string
+	next        uint32
+	retries     int
+}
+
+//NewClientResolver function - Create a resolver sending queries to the given
+//nameservers, or to the nameservers in /etc/resolv.conf if none are given
+func NewClientResolver(nameservers []string, timeout time.Duration, retries int) (*ClientResolver, error) {
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	r := ClientResolver{
+		timeout: timeout,
+		retries: retries,
+	}
+
+	if len(nameservers) == 0 {
+		clientConfig, err := dns.ClientConfigFromFile(resolvConfPath)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read nameservers from %s: %s", resolvConfPath, err)
+		}
+		for _, ns := range clientConfig.Servers {
+			nameservers = append(nameservers, net.JoinHostPort(ns, clientConfig.Port))
+		}
+	}
+
+	for _, ns := range nameservers {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(ns, defaultDNSPort)
+		}
+		r.nameservers = append(r.nameservers, ns)
+	}
+
+	if len(r.nameservers) == 0 {
+		return nil, errors.New("no nameservers configured")
+	}
+
+	return &r, nil
+}
+
+//LookupA function
+func (r *ClientResolver) LookupA(ctx context.Context, host string) ([]string, error) {
+	addrs, _, err := r.LookupATTL(ctx, host)
+	return addrs, err
+}
+
+//LookupTXT function
+func (r *ClientResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	txts, _, err := r.LookupTXTTTL(ctx, host)
+	return txts, err
+}
+
+//LookupATTL function
+func (r *ClientResolver) LookupATTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	return r.query(ctx, host, dns.TypeA)
+}
+
+//LookupTXTTTL function
+func (r *ClientResolver) LookupTXTTTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	return r.query(ctx, host, dns.TypeTXT)
+}
+
+//query sends the question to the next nameserver, retrying on failures, and
+//returns the records of type qtype in the answer with the lowest TTL among them
+func (r *ClientResolver) query(ctx context.Context, host string, qtype uint16) ([]string, time.Duration, error) {
+	msg := new(dns.Msg)
+	msg.SetQuestion(dns.Fqdn(host), qtype)
+
+	var err error
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		var in *dns.Msg
+		in, err = r.exchange(ctx, msg)
+		if err == nil {
+			switch in.Rcode {
+			case dns.RcodeSuccess, dns.RcodeNameError:
+				records, ttl := answer(in, qtype)
+				return records, ttl, nil
+			}
+			err = &QueryError{Host: host, Qtype: qtype, Rcode: in.Rcode}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, 0, err
+}
+
+//exchange sends msg to the next nameserver, repeating the query over TCP when
+//the UDP response was truncated. Each exchange has a client of its own, as
+//dns.Client.ExchangeContext sets the dialer of the client to the deadline of ctx
+//and concurrent lookups would otherwise take each other's deadlines.
+func (r *ClientResolver) exchange(ctx context.Context, msg *dns.Msg) (*dns.Msg, error) {
+	n := atomic.AddUint32(&r.next, 1)
+	ns := r.nameservers[int(n-1)%len(r.nameservers)]
+
+	client := &dns.Client{Net: "udp", Timeout: r.timeout}
+	in, _, err := client.ExchangeContext(ctx, msg, ns)
+	if err == nil && in.Truncated {
+		tcpClient := &dns.Client{Net: "tcp", Timeout: r.timeout}
+		in, _, err = tcpClient.ExchangeContext(ctx, msg, ns)
+	}
+	return in, err
+}
+
+//answer extracts the records of type qtype from in. A response without them is
+//negative and its TTL is the lower of the SOA record TTL and SOA minimum, as
+//described in RFC 2308 section 5.
+func answer(in *dns.Msg, qtype uint16) ([]string, time.Duration) {
+	records := []string{}
+	var ttl uint32
+	for i, rr := range in.Answer {
+		switch rr := rr.(type) {
+		case *dns.A:
+			if qtype == dns.TypeA {
+				records = append(records, rr.A.String())
+			}
+		case *dns.TXT:
+			if qtype == dns.TypeTXT {
+				records = append(records, strings.Join(rr.Txt, ""))
+			}
+		}
+		if i == 0 || rr.Header().Ttl < ttl {
+			ttl = rr.Header().Ttl
+		}
+	}
+	if len(records) > 0 {
+		return records, time.Duration(ttl) * time.Second
+	}
+
+	for _, rr := range in.Ns {
+		if soa, ok := rr.(*dns.SOA); ok {
+			ttl = soa.Hdr.Ttl
+			if soa.Minttl < ttl {
+				ttl = soa.Minttl
+			}
+			return records, time.Duration(ttl) * time.Second
+		}
+	}
+	return records, 0
+}
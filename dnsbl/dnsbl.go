@@ -1,34 +1,400 @@
 package dnsbl
 
 import (
-	//"encoding/json"
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
 	"github.com/egreen64/codingchallenge/config"
-	"github.com/nerdbaggy/godnsbl"
+	"github.com/egreen64/codingchallenge/utils"
 )
 
-//Return type
-type Return godnsbl.DnsblReturn
+//Return type - results of looking up an ip address on every blocklist domain
+type Return struct {
+	Err        string     // Will always be "" unless there is an error
+	Listed     bool       // If any of the blocklist domains contain this IP
+	Count      int        // How many blocklist domains have listed this IP
+	Total      int        // How many blocklist domains were checked
+	Responses  []Data     // List of all the responses
+	Categories []Category // Categories of all the listings, without duplicates
+	Severity   Severity   // Highest severity of all the categories
+
+	Allowlisted    bool    // If any of the allowlist domains contain this IP
+	AllowCount     int     // How many allowlist domains have listed this IP
+	AllowResponses []Data  // List of all the allowlist responses
+	Verdict        Verdict // Combined verdict of the blocklist and allowlist responses
+
+	Score   float64 // Weighted score of the blocklist listings less that of the allowlist listings
+	Blocked bool    // If the score reached the score threshold
+
+	LookupStatus LookupStatus // Outcome of the blocklist lookups, see combinedStatus
+}
+
+//Data type - result of looking up an ip address on a single blocklist domain
+type Data struct {
+	Status     string     // If the check completed ok
+	Msg        string     // If there was an error what was the message
+	Listed     bool       // If the IP is listed in that particular blocklist domain
+	Name       string     // Blocklist domain queried
+	RespTime   int64      // How long in milliseconds it took for a reply
+	Resp       string     // The first address the query responded with
+	Addrs      []string   // All the addresses the query responded with
+	Categories []Category // Decoded meaning of the addresses
+	Reason     string     // TXT record explaining the listing, if requested
+	Score      float64    // Weighted score of the listing
 
-//Data type
-type Data godnsbl.DnsblData
+	LookupStatus LookupStatus // Outcome of the lookup
+}
+
+const (
+	defaultMaxConcurrentQueries = 8
+	defaultScoreThreshold       = 1.0
+	defaultZoneReloadInterval   = 60 * time.Second
+)
 
 //Dnsbl instance type
 type Dnsbl struct {
-	BlocklistDomains []string
+	BlocklistDomains       []string
+	IPV6BlocklistDomains   []string
+	DomainBlocklistDomains []string
+	AllowlistDomains       []string
+	IPV6AllowlistDomains   []string
+	VerdictPolicy          VerdictPolicy
+	Weights                map[string]float64
+	ScoreThreshold         float64
+	MaxConcurrentQueries   int
+	FetchReasons           bool
+	ReturnCodes            ReturnCodeCatalog
+	ErrorCodes             ErrorCodeCatalog
+	Zones                  []*Zone
+	resolver               Resolver
+	cache                  *CachingResolver
+	sentinels              *sentinelMonitor
 }
 
-//NewDnsbl - Create DNS Blocklist instance
+//NewDnsbl - Create DNS Blocklist instance. Queries are cached for their TTL in a
+//cache holding up to CacheSize answers, unless CacheSize is 0. If no nameservers
+//are configured and none can be read from /etc/resolv.conf, queries are sent
+//through the system resolver without caching.
 func NewDnsbl(config *config.File) *Dnsbl {
+	timeout := time.Duration(config.Dnsbl.QueryTimeoutMs) * time.Millisecond
+
+	var resolver Resolver
+	clientResolver, err := NewClientResolver(config.Dnsbl.Nameservers, timeout, config.Dnsbl.QueryRetries)
+	switch {
+	case err != nil:
+		log.Printf("using system resolver for dnsbl queries, error: %s\n", err)
+		resolver = NewNativeResolver(config.Dnsbl.Nameservers, timeout, config.Dnsbl.QueryRetries)
+	case config.Dnsbl.CacheSize > 0:
+		resolver = NewCachingResolver(clientResolver, config.Dnsbl.CacheSize)
+	default:
+		resolver = clientResolver
+	}
+
+	return NewDnsblWithResolver(config, resolver)
+}
+
+//NewDnsblWithResolver - Create DNS Blocklist instance using the supplied resolver
+func NewDnsblWithResolver(config *config.File, resolver Resolver) *Dnsbl {
 	dnsbl := Dnsbl{
-		BlocklistDomains: config.Dnsbl.BlocklistDomains,
+		BlocklistDomains:       config.Dnsbl.BlocklistDomains,
+		DomainBlocklistDomains: config.Dnsbl.DomainBlocklistDomains,
+		AllowlistDomains:       config.Dnsbl.AllowlistDomains,
+		Weights:                map[string]float64{},
+		ScoreThreshold:         config.Dnsbl.ScoreThreshold,
+		MaxConcurrentQueries:   config.Dnsbl.MaxConcurrentQueries,
+		FetchReasons:           config.Dnsbl.FetchTXTRecords,
+		ErrorCodes:             NewErrorCodeCatalog(config.Dnsbl.Blocklists),
+		resolver:               resolver,
+		sentinels:              newSentinelMonitor(),
+	}
+	if dnsbl.MaxConcurrentQueries <= 0 {
+		dnsbl.MaxConcurrentQueries = defaultMaxConcurrentQueries
+	}
+	if dnsbl.ScoreThreshold <= 0 {
+		dnsbl.ScoreThreshold = defaultScoreThreshold
+	}
+	for domain, blocklist := range config.Dnsbl.Blocklists {
+		if blocklist.Weight != nil {
+			dnsbl.Weights[strings.ToLower(domain)] = *blocklist.Weight
+		}
+	}
+
+	//IPV6 addresses are only looked up on the blocklist and allowlist domains that serve them
+	for _, domain := range dnsbl.BlocklistDomains {
+		if config.Dnsbl.Blocklists[domain].SupportsIPV6 {
+			dnsbl.IPV6BlocklistDomains = append(dnsbl.IPV6BlocklistDomains, domain)
+		}
+	}
+	for _, domain := range dnsbl.AllowlistDomains {
+		if config.Dnsbl.Blocklists[domain].SupportsIPV6 {
+			dnsbl.IPV6AllowlistDomains = append(dnsbl.IPV6AllowlistDomains, domain)
+		}
+	}
+
+	verdictPolicy, err := ParseVerdictPolicy(config.Dnsbl.VerdictPolicy)
+	if err != nil {
+		log.Fatalf("invalid dnsbl verdict policy configuration: %s\n", err)
+	}
+	dnsbl.VerdictPolicy = verdictPolicy
+
+	returnCodes, err := NewReturnCodeCatalog(config.Dnsbl.Blocklists)
+	if err != nil {
+		log.Fatalf("invalid dnsbl return code configuration: %s\n", err)
+	}
+	dnsbl.ReturnCodes = returnCodes
+
+	if cache, ok := resolver.(*CachingResolver); ok {
+		dnsbl.cache = cache
+	}
+
+	//Blocklist domains with a zone file are looked up locally instead of being queried
+	for domain, blocklist := range config.Dnsbl.Blocklists {
+		if blocklist.ZoneFile == "" {
+			continue
+		}
+		zone, err := LoadZone(domain, blocklist.ZoneFile, blocklist.ZoneFormat)
+		if err != nil {
+			log.Fatalf("unable to load zone file %s for blocklist domain %s, error: %s\n", blocklist.ZoneFile, domain, err)
+		}
+		dnsbl.Zones = append(dnsbl.Zones, zone)
+	}
+	if len(dnsbl.Zones) > 0 {
+		zoneResolver := NewZoneResolver(resolver, dnsbl.Zones)
+		dnsbl.resolver = zoneResolver
+
+		interval := time.Duration(config.Dnsbl.ZoneReloadIntervalSeconds) * time.Second
+		if interval <= 0 {
+			interval = defaultZoneReloadInterval
+		}
+		go zoneResolver.watch(interval)
 	}
+
 	return &dnsbl
 }
 
-//Lookup - Blocklist Domain Lookup
+//Stop function - stops reloading the zone files, if any
+func (d *Dnsbl) Stop() {
+	if zoneResolver, ok := d.resolver.(*ZoneResolver); ok {
+		zoneResolver.Stop()
+	}
+}
+
+//CacheStats function - returns the counters of the query cache, which are all 0
+//when queries are not cached
+func (d *Dnsbl) CacheStats() CacheStats {
+	if d.cache == nil {
+		return CacheStats{}
+	}
+	return d.cache.Stats()
+}
+
+//Warnings function - returns the error codes answered by the blocklist domains within
+//the last 15 minutes. An error code usually means the blocklist refuses queries from
+//the configured nameservers, so that no address can be found listed on it.
+func (d *Dnsbl) Warnings() []SentinelWarning {
+	return d.sentinels.active()
+}
+
+//Lookup - Blocklist Domain Lookup. All blocklist and allowlist domains are
+//queried in parallel, bounded by MaxConcurrentQueries, and the responses are
+//returned in the same order as BlocklistDomains and AllowlistDomains. IPV6
+//addresses are only looked up on IPV6BlocklistDomains and IPV6AllowlistDomains.
 func (d *Dnsbl) Lookup(ipAddress string) Return {
-	godnsbl.BlacklistDomains = d.BlocklistDomains
-	resp := godnsbl.CheckBlacklist(ipAddress)
+	return d.LookupContext(context.Background(), ipAddress)
+}
+
+//LookupContext - as Lookup, the queries still unanswered when ctx is done failing
+//with a TIMEOUT lookup status
+func (d *Dnsbl) LookupContext(ctx context.Context, ipAddress string) Return {
+	var resp Return
+
+	ip := net.ParseIP(ipAddress)
+	if ip == nil {
+		resp.Err = fmt.Sprintf("invalid IP address: %s", ipAddress)
+		resp.LookupStatus = StatusError
+		return resp
+	}
+
+	query, blocklists, allowlists := "", d.BlocklistDomains, d.AllowlistDomains
+	if ip4 := ip.To4(); ip4 != nil {
+		query = reverseIPV4(ip4)
+	} else {
+		query, blocklists, allowlists = reverseIPV6(ip), d.IPV6BlocklistDomains, d.IPV6AllowlistDomains
+	}
+
+	return d.lookup(ctx, query, blocklists, allowlists)
+}
+
+//LookupDomain - Domain Blocklist Lookup. The domain is looked up on every
+//DomainBlocklistDomains entry (RHSBL) in the same way as Lookup. Allowlists
+//are not used for domains.
+func (d *Dnsbl) LookupDomain(domain string) Return {
+	var resp Return
+
+	if !utils.IsValidDomain(domain) {
+		resp.Err = fmt.Sprintf("invalid domain: %s", domain)
+		resp.LookupStatus = StatusError
+		return resp
+	}
+
+	return d.lookup(context.Background(), utils.NormalizeDomain(domain), d.DomainBlocklistDomains, nil)
+}
+
+func (d *Dnsbl) lookup(ctx context.Context, query string, blocklists []string, allowlists []string) Return {
+	var resp Return
+
+	domains := make([]string, 0, len(blocklists)+len(allowlists))
+	domains = append(append(domains, blocklists...), allowlists...)
+
+	responses := d.lookupDomains(ctx, query, domains)
+	resp.Responses = responses[:len(blocklists)]
+	resp.AllowResponses = responses[len(blocklists):]
+
+	for i, data := range resp.Responses {
+		if data.Listed {
+			resp.Listed = true
+			resp.Count++
+		}
+		resp.Responses[i].Score = d.listingScore(data)
+		resp.Score += resp.Responses[i].Score
+		for _, category := range data.Categories {
+			resp.Categories = appendCategory(resp.Categories, category)
+			if category.Severity > resp.Severity {
+				resp.Severity = category.Severity
+			}
+		}
+	}
+	resp.Total = len(blocklists)
+	resp.LookupStatus = combinedStatus(resp.Responses)
+
+	for i, data := range resp.AllowResponses {
+		if data.Listed {
+			resp.Allowlisted = true
+			resp.AllowCount++
+		}
+		resp.AllowResponses[i].Score = d.listingScore(data)
+		resp.Score -= resp.AllowResponses[i].Score
+	}
+
+	resp.Blocked = resp.Score >= d.ScoreThreshold
+	resp.Verdict = d.VerdictPolicy.Verdict(resp)
+
+	return resp
+}
+
+func (d *Dnsbl) lookupDomains(ctx context.Context, query string, domains []string) []Data {
+	responses := make([]Data, len(domains))
+	sem := make(chan struct{}, d.MaxConcurrentQueries)
+
+	var wg sync.WaitGroup
+	for i, domain := range domains {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, domain string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			responses[i] = d.lookupDomain(ctx, query, domain)
+		}(i, domain)
+	}
+	wg.Wait()
+
+	return responses
+}
+
+func (d *Dnsbl) lookupDomain(ctx context.Context, query string, domain string) Data {
+	data := Data{
+		Status:       "ok",
+		Name:         domain,
+		LookupStatus: StatusNotListed,
+	}
 
-	return Return(resp)
+	name := fmt.Sprintf("%s.%s.", query, strings.TrimSuffix(domain, "."))
+
+	start := time.Now()
+	addrs, err := d.resolver.LookupA(ctx, name)
+	data.RespTime = time.Since(start).Milliseconds()
+
+	//An error code is a failure to look the address up, not a listing
+	if err == nil {
+		if code, ok := d.ErrorCodes.Find(domain, addrs); ok {
+			err = &ErrorCodeError{Domain: domain, Code: code}
+			d.sentinels.record(domain, code)
+			log.Printf("dnsbl query for %s refused: %s\n", name, err)
+		}
+	}
+
+	switch {
+	case err != nil:
+		data.Status = "error"
+		data.Msg = err.Error()
+		data.LookupStatus = errorStatus(err)
+	case len(addrs) > 0:
+		//The first address is reported as the response code, but every address is decoded
+		data.Listed = true
+		data.LookupStatus = StatusListed
+		data.Resp = addrs[0]
+		data.Addrs = addrs
+		data.Categories = d.ReturnCodes.Decode(domain, addrs)
+
+		if d.FetchReasons {
+			data.Reason = d.lookupReason(ctx, name)
+		}
+	}
+
+	return data
+}
+
+//listingScore returns the weight of the blocklist or allowlist domain multiplied by
+//the highest weight of the return codes it answered with, or 0 if not listed
+func (d *Dnsbl) listingScore(data Data) float64 {
+	if !data.Listed {
+		return 0
+	}
+
+	codeWeight := 0.0
+	for _, category := range data.Categories {
+		if category.Weight > codeWeight {
+			codeWeight = category.Weight
+		}
+	}
+
+	weight, ok := d.Weights[strings.ToLower(data.Name)]
+	if !ok {
+		weight = defaultWeight
+	}
+	return weight * codeWeight
+}
+
+//lookupReason returns the TXT records published next to a listing. Failing to
+//retrieve them does not affect the listing itself, so errors are only logged.
+func (d *Dnsbl) lookupReason(ctx context.Context, name string) string {
+	txts, err := d.resolver.LookupTXT(ctx, name)
+	if err != nil {
+		log.Printf("unable to retrieve txt record for %s, error: %s\n", name, err)
+		return ""
+	}
+	return strings.Join(txts, " ")
+}
+
+//reverseIPV4 returns the octets of ip in reverse order as used in DNSBL query names
+func reverseIPV4(ip net.IP) string {
+	return fmt.Sprintf("%d.%d.%d.%d", ip[3], ip[2], ip[1], ip[0])
+}
+
+//reverseIPV6 returns the nibbles of ip in reverse order as used in DNSBL query
+//names, as described in RFC 5782 section 2.4
+func reverseIPV6(ip net.IP) string {
+	const hexDigits = "0123456789abcdef"
+
+	ip = ip.To16()
+	nibbles := make([]string, 0, 2*len(ip))
+	for i := len(ip) - 1; i >= 0; i-- {
+		nibbles = append(nibbles, string(hexDigits[ip[i]&0x0f]), string(hexDigits[ip[i]>>4]))
+	}
+	return strings.Join(nibbles, ".")
 }
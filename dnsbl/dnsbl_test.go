@@ -1,15 +1,101 @@
 package dnsbl
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"log"
+	"net"
 	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/egreen64/codingchallenge/config"
+	"github.com/egreen64/codingchallenge/dnsbl/dnsbltest"
+	"github.com/miekg/dns"
 	"github.com/stretchr/testify/require"
 )
 
+//stubResolver answers A queries for the hosts it contains and TXT queries for
+//the hosts it contains prefixed with "TXT "
+type stubResolver map[string][]string
+
+func (s stubResolver) LookupA(ctx context.Context, host string) ([]string, error) {
+	addrs, ok := s[host]
+	if !ok {
+		return nil, errors.New("stub resolver failure")
+	}
+	return addrs, nil
+}
+
+func (s stubResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	return s["TXT "+host], nil
+}
+
+//ttlStubResolver answers A queries for the hosts it contains with the given TTL
+//and counts the queries it received
+type ttlStubResolver struct {
+	stubResolver
+	ttl     time.Duration
+	queries int
+}
+
+func (s *ttlStubResolver) LookupATTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	s.queries++
+	addrs, err := s.LookupA(ctx, host)
+	return addrs, s.ttl, err
+}
+
+func (s *ttlStubResolver) LookupTXTTTL(ctx context.Context, host string) ([]string, time.Duration, error) {
+	s.queries++
+	txts, err := s.LookupTXT(ctx, host)
+	return txts, s.ttl, err
+}
+
+//startDNSServer starts a DNS server on a random local UDP port answering with handler
+func startDNSServer(t *testing.T, handler dns.HandlerFunc) string {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	require.Equal(t, nil, err)
+
+	server := &dns.Server{PacketConn: pc, Handler: handler}
+	go server.ActivateAndServe()
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String()
+}
+
+//withZoneFile returns a copy of c in which domain is looked up in the zone file at path
+func withZoneFile(c *config.File, domain string, path string) *config.File {
+	zoneConfig := *c
+	zoneConfig.Dnsbl.Blocklists = map[string]config.Blocklist{}
+	for d, blocklist := range c.Dnsbl.Blocklists {
+		zoneConfig.Dnsbl.Blocklists[d] = blocklist
+	}
+
+	blocklist := zoneConfig.Dnsbl.Blocklists[domain]
+	blocklist.ZoneFile = path
+	zoneConfig.Dnsbl.Blocklists[domain] = blocklist
+
+	return &zoneConfig
+}
+
+//withReturnCode returns a copy of c in which code of domain is mapped to category
+func withReturnCode(c *config.File, domain string, code string, category string, severity string, weight *float64) *config.File {
+	codeConfig := *c
+	codeConfig.Dnsbl.Blocklists = map[string]config.Blocklist{}
+	for d, blocklist := range c.Dnsbl.Blocklists {
+		codeConfig.Dnsbl.Blocklists[d] = blocklist
+	}
+
+	blocklist := codeConfig.Dnsbl.Blocklists[domain]
+	blocklist.ReturnCodes = append([]config.ReturnCode{}, blocklist.ReturnCodes...)
+	blocklist.ReturnCodes = append(blocklist.ReturnCodes, config.ReturnCode{Codes: []string{code}, Category: category, Severity: severity, Weight: weight})
+	codeConfig.Dnsbl.Blocklists[domain] = blocklist
+
+	return &codeConfig
+}
+
 func TestDnsbl(t *testing.T) {
 	//Disable logging
 	log.SetOutput(ioutil.Discard)
@@ -20,6 +106,11 @@ func TestDnsbl(t *testing.T) {
 	//Get config file
 	config := config.GetConfig()
 
+	//Answer blocklist queries from a local server loaded with the zen.spamhaus.org test addresses
+	server := dnsbltest.NewServer(dnsbltest.SpamhausFixtures, "zen.spamhaus.org")
+	defer server.Close()
+	config.Dnsbl.Nameservers = []string{server.Addr}
+
 	dnsbl := NewDnsbl(config)
 
 	t.Run("new_dnsbl_success", func(t *testing.T) {
@@ -84,4 +175,710 @@ func TestDnsbl(t *testing.T) {
 		require.Equal(t, false, resp.Responses[0].Listed)
 		require.Equal(t, "", resp.Responses[0].Resp)
 	})
+
+	t.Run("lookup_success_stub_resolver", func(t *testing.T) {
+
+		stubDnsbl := NewDnsblWithResolver(config, stubResolver{
+			"2.0.0.127.zen.spamhaus.org.": {"127.0.0.2", "127.0.0.4"},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, 1, resp.Count)
+		require.Equal(t, "ok", resp.Responses[0].Status)
+		require.Equal(t, "127.0.0.2", resp.Responses[0].Resp)
+	})
+
+	t.Run("lookup_failure_stub_resolver_error", func(t *testing.T) {
+
+		stubDnsbl := NewDnsblWithResolver(config, stubResolver{})
+
+		resp := stubDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, false, resp.Listed)
+		require.Equal(t, "error", resp.Responses[0].Status)
+		require.Equal(t, "stub resolver failure", resp.Responses[0].Msg)
+	})
+
+	t.Run("lookup_failure_invalid_ip_address", func(t *testing.T) {
+
+		resp := dnsbl.Lookup("127.0.0.256")
+
+		require.Equal(t, "invalid IP address: 127.0.0.256", resp.Err)
+		require.Empty(t, resp.Responses)
+	})
+
+	t.Run("lookup_failure_unreachable_nameserver", func(t *testing.T) {
+
+		resolver := NewNativeResolver([]string{"127.0.0.1:1"}, 200*time.Millisecond, 1)
+		unreachableDnsbl := NewDnsblWithResolver(config, resolver)
+
+		resp := unreachableDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, false, resp.Listed)
+		require.Equal(t, "error", resp.Responses[0].Status)
+	})
+
+	t.Run("lookup_success_multiple_blocklist_domains", func(t *testing.T) {
+
+		multiConfig := *config
+		multiConfig.Dnsbl.BlocklistDomains = []string{"zen.spamhaus.org", "bl.spamcop.net", "b.barracudacentral.org"}
+		multiConfig.Dnsbl.MaxConcurrentQueries = 2
+
+		stubDnsbl := NewDnsblWithResolver(&multiConfig, stubResolver{
+			"2.0.0.127.zen.spamhaus.org.":       {"127.0.0.4"},
+			"2.0.0.127.bl.spamcop.net.":         {},
+			"2.0.0.127.b.barracudacentral.org.": {"127.0.0.2"},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, 2, resp.Count)
+		require.Equal(t, 3, resp.Total)
+		require.Equal(t, "zen.spamhaus.org", resp.Responses[0].Name)
+		require.Equal(t, "127.0.0.4", resp.Responses[0].Resp)
+		require.Equal(t, "bl.spamcop.net", resp.Responses[1].Name)
+		require.Equal(t, false, resp.Responses[1].Listed)
+		require.Equal(t, "b.barracudacentral.org", resp.Responses[2].Name)
+		require.Equal(t, "127.0.0.2", resp.Responses[2].Resp)
+	})
+
+	t.Run("lookup_success_categories", func(t *testing.T) {
+
+		stubDnsbl := NewDnsblWithResolver(config, stubResolver{
+			"9.0.0.127.zen.spamhaus.org.": {"127.0.0.2", "127.0.0.9"},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.9")
+
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, []Category{{"SBL", SeverityHigh, 1.0}, {"DROP", SeverityCritical, 2.0}}, resp.Categories)
+		require.Equal(t, SeverityCritical, resp.Severity)
+		require.Equal(t, []string{"127.0.0.2", "127.0.0.9"}, resp.Responses[0].Addrs)
+	})
+
+	t.Run("lookup_success_unknown_category", func(t *testing.T) {
+
+		stubDnsbl := NewDnsblWithResolver(config, stubResolver{
+			"2.0.0.127.zen.spamhaus.org.": {"127.0.0.99"},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, []Category{UnknownCategory}, resp.Categories)
+		require.Equal(t, SeverityMedium, resp.Severity)
+	})
+
+	t.Run("lookup_success_not_listed_no_categories", func(t *testing.T) {
+
+		stubDnsbl := NewDnsblWithResolver(config, stubResolver{
+			"255.0.0.127.zen.spamhaus.org.": {},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.255")
+
+		require.Equal(t, false, resp.Listed)
+		require.Empty(t, resp.Categories)
+		require.Equal(t, SeverityNone, resp.Severity)
+	})
+
+	t.Run("parse_severity_success", func(t *testing.T) {
+
+		severity, err := ParseSeverity("pbl")
+		require.EqualError(t, err, "invalid severity: pbl")
+		require.Equal(t, SeverityNone, severity)
+
+		severity, err = ParseSeverity("high")
+		require.Equal(t, nil, err)
+		require.Equal(t, SeverityHigh, severity)
+		require.Equal(t, "HIGH", severity.String())
+	})
+
+	t.Run("lookup_success_reason", func(t *testing.T) {
+
+		stubDnsbl := NewDnsblWithResolver(config, stubResolver{
+			"2.0.0.127.zen.spamhaus.org.":     {"127.0.0.2"},
+			"TXT 2.0.0.127.zen.spamhaus.org.": {"https://www.spamhaus.org/sbl/query/SBL2"},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, "https://www.spamhaus.org/sbl/query/SBL2", resp.Responses[0].Reason)
+	})
+
+	t.Run("lookup_success_reason_disabled", func(t *testing.T) {
+
+		noReasonConfig := *config
+		noReasonConfig.Dnsbl.FetchTXTRecords = false
+
+		stubDnsbl := NewDnsblWithResolver(&noReasonConfig, stubResolver{
+			"2.0.0.127.zen.spamhaus.org.":     {"127.0.0.2"},
+			"TXT 2.0.0.127.zen.spamhaus.org.": {"https://www.spamhaus.org/sbl/query/SBL2"},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, "", resp.Responses[0].Reason)
+	})
+
+	t.Run("reverse_ipv6_success", func(t *testing.T) {
+
+		query := reverseIPV6(net.ParseIP("2001:db8:1:2:3:4:567:89ab"))
+		require.Equal(t, "b.a.9.8.7.6.5.0.4.0.0.0.3.0.0.0.2.0.0.0.1.0.0.0.8.b.d.0.1.0.0.2", query)
+	})
+
+	t.Run("lookup_success_ipv6", func(t *testing.T) {
+
+		ipv6Config := *config
+		ipv6Config.Dnsbl.BlocklistDomains = []string{"zen.spamhaus.org", "bl.spamcop.net"}
+
+		stubDnsbl := NewDnsblWithResolver(&ipv6Config, stubResolver{
+			"1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.zen.spamhaus.org.": {"127.0.0.3"},
+		})
+
+		resp := stubDnsbl.Lookup("2001:db8::1")
+
+		require.Equal(t, "", resp.Err)
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, 1, resp.Total)
+		require.Len(t, resp.Responses, 1)
+		require.Equal(t, "zen.spamhaus.org", resp.Responses[0].Name)
+		require.Equal(t, "127.0.0.3", resp.Responses[0].Resp)
+	})
+
+	t.Run("lookup_success_ipv6_no_supporting_domains", func(t *testing.T) {
+
+		ipv4Config := *config
+		ipv4Config.Dnsbl.BlocklistDomains = []string{"bl.spamcop.net"}
+
+		stubDnsbl := NewDnsblWithResolver(&ipv4Config, stubResolver{})
+
+		resp := stubDnsbl.Lookup("2001:db8::1")
+
+		require.Equal(t, "", resp.Err)
+		require.Equal(t, false, resp.Listed)
+		require.Equal(t, 0, resp.Total)
+		require.Empty(t, resp.Responses)
+	})
+
+	t.Run("lookup_domain_success", func(t *testing.T) {
+
+		stubDnsbl := NewDnsblWithResolver(config, stubResolver{
+			"xn--mnchen-3ya.de.dbl.spamhaus.org.": {"127.0.1.4"},
+		})
+
+		resp := stubDnsbl.LookupDomain("München.de")
+
+		require.Equal(t, "", resp.Err)
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, "dbl.spamhaus.org", resp.Responses[0].Name)
+		require.Equal(t, []Category{{"DBL-PHISH", SeverityHigh, 1.0}}, resp.Categories)
+	})
+
+	t.Run("lookup_domain_failure_invalid_domain", func(t *testing.T) {
+
+		resp := dnsbl.LookupDomain("exa_mple.com")
+
+		require.Equal(t, "invalid domain: exa_mple.com", resp.Err)
+		require.Empty(t, resp.Responses)
+	})
+
+	t.Run("lookup_success_allowlist_verdict", func(t *testing.T) {
+
+		allowConfig := *config
+		allowConfig.Dnsbl.AllowlistDomains = []string{"list.dnswl.org"}
+		allowConfig.Dnsbl.VerdictPolicy = "allowlist_wins"
+
+		stubDnsbl := NewDnsblWithResolver(&allowConfig, stubResolver{
+			"2.0.0.127.zen.spamhaus.org.": {"127.0.0.3"},
+			"2.0.0.127.list.dnswl.org.":   {"127.0.10.2"},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, true, resp.Allowlisted)
+		require.Equal(t, 1, resp.Total)
+		require.Len(t, resp.AllowResponses, 1)
+		require.Equal(t, "list.dnswl.org", resp.AllowResponses[0].Name)
+		require.Equal(t, "127.0.10.2", resp.AllowResponses[0].Resp)
+		require.Equal(t, []Category{{"CSS", SeverityMedium, 0.5}}, resp.Categories)
+		require.Equal(t, VerdictAllow, resp.Verdict)
+	})
+
+	t.Run("lookup_success_score", func(t *testing.T) {
+
+		stubDnsbl := NewDnsblWithResolver(config, stubResolver{
+			"9.0.0.127.zen.spamhaus.org.":  {"127.0.0.2", "127.0.0.9"},
+			"10.0.0.127.zen.spamhaus.org.": {"127.0.0.10"},
+		})
+
+		resp := stubDnsbl.Lookup("127.0.0.9")
+
+		require.Equal(t, 2.0, resp.Score)
+		require.Equal(t, 2.0, resp.Responses[0].Score)
+		require.Equal(t, true, resp.Blocked)
+
+		resp = stubDnsbl.Lookup("127.0.0.10")
+
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, 0.25, resp.Score)
+		require.Equal(t, false, resp.Blocked)
+
+		resp = stubDnsbl.Lookup("127.0.0.1")
+
+		require.Equal(t, 0.0, resp.Score)
+		require.Equal(t, false, resp.Blocked)
+	})
+
+	t.Run("new_return_code_catalog_success", func(t *testing.T) {
+
+		weight := 0.25
+		codeConfig := withReturnCode(config, "ZEN.spamhaus.org", "127.0.0.3", "CSS", "LOW", &weight)
+		codeConfig = withReturnCode(codeConfig, "bl.example.org", "127.0.0.2", "SPAM", "HIGH", nil)
+		catalog, err := NewReturnCodeCatalog(codeConfig.Dnsbl.Blocklists)
+		require.Equal(t, nil, err)
+
+		//The configured return codes override the Spamhaus ones, which are otherwise kept
+		require.Equal(t, []Category{{"SBL", SeverityHigh, 1.0}, {"CSS", SeverityLow, 0.25}}, catalog.Decode("zen.spamhaus.org", []string{"127.0.0.2", "127.0.0.3"}))
+		require.Equal(t, []Category{{"DBL-SPAM", SeverityHigh, 1.0}}, catalog.Decode("dbl.spamhaus.org", []string{"127.0.1.2"}))
+		require.Equal(t, []Category{{"SPAM", SeverityHigh, 1.0}, UnknownCategory}, catalog.Decode("bl.example.org", []string{"127.0.0.2", "127.0.0.3"}))
+
+		//The Spamhaus return codes are left untouched
+		require.Equal(t, Category{"CSS", SeverityMedium, 0.5}, defaultReturnCodes["zen.spamhaus.org"]["127.0.0.3"])
+
+		_, err = NewReturnCodeCatalog(withReturnCode(config, "bl.example.org", "127.0.0.2", "SPAM", "SEVERE", nil).Dnsbl.Blocklists)
+		require.EqualError(t, err, "blocklist domain bl.example.org, category SPAM: invalid severity: SEVERE")
+	})
+
+	t.Run("lookup_success_score_weights", func(t *testing.T) {
+
+		weightConfig := *config
+		weightConfig.Dnsbl.BlocklistDomains = []string{"bl.example.org"}
+		weightConfig.Dnsbl.AllowlistDomains = []string{"list.dnswl.org"}
+		weightConfig.Dnsbl.VerdictPolicy = "score"
+		weightConfig.Dnsbl.ScoreThreshold = 2.0
+
+		stubDnsbl := NewDnsblWithResolver(&weightConfig, stubResolver{
+			"2.0.0.127.bl.example.org.": {"127.0.0.2"},
+			"2.0.0.127.list.dnswl.org.": {"127.0.10.2"},
+			"3.0.0.127.bl.example.org.": {"127.0.0.3"},
+			"3.0.0.127.list.dnswl.org.": {},
+		})
+		stubDnsbl.Weights["bl.example.org"] = 3.0
+		stubDnsbl.ReturnCodes["bl.example.org"] = map[string]Category{"127.0.0.2": {"SPAM", SeverityHigh, 0.5}}
+
+		//1.5 for the blocklist listing less 1.0 for the allowlist listing with default weights
+		resp := stubDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, 0.5, resp.Score)
+		require.Equal(t, 1.0, resp.AllowResponses[0].Score)
+		require.Equal(t, false, resp.Blocked)
+		require.Equal(t, VerdictBlock, resp.Verdict)
+
+		//Unknown return codes have the default weight
+		resp = stubDnsbl.Lookup("127.0.0.3")
+
+		require.Equal(t, 3.0, resp.Score)
+		require.Equal(t, true, resp.Blocked)
+	})
+
+	t.Run("verdict_policy_success", func(t *testing.T) {
+
+		neither := Return{}
+		blocked := Return{Listed: true, Count: 1}
+		allowed := Return{Allowlisted: true, AllowCount: 1}
+		both := Return{Listed: true, Count: 2, Allowlisted: true, AllowCount: 1, Score: 1.0}
+		tied := Return{Listed: true, Count: 1, Allowlisted: true, AllowCount: 1, Score: 0}
+
+		for _, policy := range []VerdictPolicy{PolicyAllowlistWins, PolicyBlocklistWins, PolicyScore} {
+			require.Equal(t, VerdictNeutral, policy.Verdict(neither))
+			require.Equal(t, VerdictBlock, policy.Verdict(blocked))
+			require.Equal(t, VerdictAllow, policy.Verdict(allowed))
+		}
+
+		require.Equal(t, VerdictAllow, PolicyAllowlistWins.Verdict(both))
+		require.Equal(t, VerdictBlock, PolicyBlocklistWins.Verdict(both))
+		require.Equal(t, VerdictBlock, PolicyScore.Verdict(both))
+		require.Equal(t, VerdictNeutral, PolicyScore.Verdict(tied))
+	})
+
+	t.Run("parse_verdict_policy_success", func(t *testing.T) {
+
+		policy, err := ParseVerdictPolicy("")
+		require.Equal(t, nil, err)
+		require.Equal(t, PolicyBlocklistWins, policy)
+
+		_, err = ParseVerdictPolicy("majority")
+		require.EqualError(t, err, "invalid verdict policy: majority")
+	})
+
+	t.Run("caching_resolver_success", func(t *testing.T) {
+
+		now := time.Now()
+		stub := &ttlStubResolver{
+			stubResolver: stubResolver{
+				"2.0.0.127.zen.spamhaus.org.": {"127.0.0.2"},
+				"2.0.0.127.list.dnswl.org.":   {},
+				"1.0.0.127.zen.spamhaus.org.": {},
+				"1.0.0.127.list.dnswl.org.":   {},
+			},
+			ttl: time.Minute,
+		}
+		cache := NewCachingResolver(stub, 10)
+		cache.now = func() time.Time { return now }
+
+		stubDnsbl := NewDnsblWithResolver(config, cache)
+
+		for i := 0; i < 3; i++ {
+			resp := stubDnsbl.Lookup("127.0.0.2")
+			require.Equal(t, true, resp.Listed)
+			resp = stubDnsbl.Lookup("127.0.0.1")
+			require.Equal(t, false, resp.Listed)
+		}
+
+		//An A and a TXT query for the listing and an A query for each of the 3 negative answers
+		require.Equal(t, 5, stub.queries)
+		require.Equal(t, CacheStats{Hits: 10, Misses: 5, Entries: 5, Capacity: 10}, stubDnsbl.CacheStats())
+
+		now = now.Add(time.Minute)
+		stubDnsbl.Lookup("127.0.0.1")
+		require.Equal(t, 7, stub.queries)
+	})
+
+	t.Run("caching_resolver_success_lru_eviction", func(t *testing.T) {
+
+		stub := &ttlStubResolver{
+			stubResolver: stubResolver{
+				"a.example.": {"127.0.0.2"},
+				"b.example.": {"127.0.0.3"},
+				"c.example.": {"127.0.0.4"},
+			},
+			ttl: time.Minute,
+		}
+		cache := NewCachingResolver(stub, 2)
+
+		ctx := context.Background()
+		cache.LookupA(ctx, "a.example.")
+		cache.LookupA(ctx, "b.example.")
+		cache.LookupA(ctx, "a.example.")
+		cache.LookupA(ctx, "c.example.")
+		require.Equal(t, 3, stub.queries)
+
+		//b.example. was the least recently used answer when c.example. was added
+		cache.LookupA(ctx, "a.example.")
+		require.Equal(t, 3, stub.queries)
+		cache.LookupA(ctx, "b.example.")
+		require.Equal(t, 4, stub.queries)
+		require.Equal(t, 2, cache.Stats().Entries)
+	})
+
+	t.Run("caching_resolver_failure_not_cached", func(t *testing.T) {
+
+		stub := &ttlStubResolver{stubResolver: stubResolver{}, ttl: time.Minute}
+		cache := NewCachingResolver(stub, 10)
+
+		_, err := cache.LookupA(context.Background(), "a.example.")
+		require.EqualError(t, err, "stub resolver failure")
+		_, err = cache.LookupA(context.Background(), "a.example.")
+		require.EqualError(t, err, "stub resolver failure")
+		require.Equal(t, 2, stub.queries)
+		require.Equal(t, 0, cache.Stats().Entries)
+	})
+
+	t.Run("client_resolver_success_ttl", func(t *testing.T) {
+
+		addr := startDNSServer(t, func(w dns.ResponseWriter, req *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(req)
+			switch req.Question[0].Name {
+			case "2.0.0.127.zen.spamhaus.org.":
+				rr, _ := dns.NewRR("2.0.0.127.zen.spamhaus.org. 300 IN A 127.0.0.2")
+				m.Answer = append(m.Answer, rr)
+			default:
+				m.Rcode = dns.RcodeNameError
+				rr, _ := dns.NewRR("zen.spamhaus.org. 150 IN SOA need.to.know.only. hostmaster.spamhaus.org. 1 3600 600 432000 10")
+				m.Ns = append(m.Ns, rr)
+			}
+			w.WriteMsg(m)
+		})
+
+		resolver, err := NewClientResolver([]string{addr}, time.Second, 0)
+		require.Equal(t, nil, err)
+
+		addrs, ttl, err := resolver.LookupATTL(context.Background(), "2.0.0.127.zen.spamhaus.org.")
+		require.Equal(t, nil, err)
+		require.Equal(t, []string{"127.0.0.2"}, addrs)
+		require.Equal(t, 300*time.Second, ttl)
+
+		//The negative TTL is the SOA minimum when it is lower than the SOA TTL
+		addrs, ttl, err = resolver.LookupATTL(context.Background(), "1.0.0.127.zen.spamhaus.org.")
+		require.Equal(t, nil, err)
+		require.Equal(t, []string{}, addrs)
+		require.Equal(t, 10*time.Second, ttl)
+	})
+
+	t.Run("lookup_failure_servfail", func(t *testing.T) {
+
+		failServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.ServFail}})
+		defer failServer.Close()
+
+		resolver, err := NewClientResolver([]string{failServer.Addr}, time.Second, 1)
+		require.Equal(t, nil, err)
+		failDnsbl := NewDnsblWithResolver(config, resolver)
+
+		resp := failDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, false, resp.Listed)
+		require.Equal(t, "error", resp.Responses[0].Status)
+		require.Equal(t, "A query for 2.0.0.127.zen.spamhaus.org. failed: SERVFAIL", resp.Responses[0].Msg)
+		require.Equal(t, StatusError, resp.Responses[0].LookupStatus)
+		require.Equal(t, StatusError, resp.LookupStatus)
+		//Each blocklist and allowlist domain is queried once and retried once
+		require.Equal(t, 4, failServer.Queries())
+	})
+
+	t.Run("lookup_failure_timeout", func(t *testing.T) {
+
+		timeoutServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Timeout}}, "zen.spamhaus.org")
+		defer timeoutServer.Close()
+
+		resolver, err := NewClientResolver([]string{timeoutServer.Addr}, 100*time.Millisecond, 0)
+		require.Equal(t, nil, err)
+		timeoutDnsbl := NewDnsblWithResolver(config, resolver)
+
+		resp := timeoutDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, false, resp.Listed)
+		require.Equal(t, "error", resp.Responses[0].Status)
+		require.Contains(t, resp.Responses[0].Msg, "timeout")
+		require.Equal(t, StatusTimeout, resp.LookupStatus)
+		require.Equal(t, "ok", resp.AllowResponses[0].Status)
+	})
+
+	t.Run("lookup_failure_context_deadline", func(t *testing.T) {
+
+		timeoutServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Timeout}}, "zen.spamhaus.org")
+		defer timeoutServer.Close()
+
+		resolver, err := NewClientResolver([]string{timeoutServer.Addr}, 5*time.Second, 0)
+		require.Equal(t, nil, err)
+		timeoutDnsbl := NewDnsblWithResolver(config, resolver)
+
+		//The lookup ends with the context, well before the query timeout
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		resp := timeoutDnsbl.LookupContext(ctx, "127.0.0.2")
+
+		require.Less(t, int64(time.Since(start)), int64(time.Second))
+		require.Equal(t, StatusTimeout, resp.LookupStatus)
+	})
+
+	t.Run("lookup_failure_refused", func(t *testing.T) {
+
+		refusedServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Refused}}, "zen.spamhaus.org")
+		defer refusedServer.Close()
+
+		resolver, err := NewClientResolver([]string{refusedServer.Addr}, time.Second, 0)
+		require.Equal(t, nil, err)
+		refusedDnsbl := NewDnsblWithResolver(config, resolver)
+
+		resp := refusedDnsbl.Lookup("127.0.0.2")
+
+		require.Equal(t, false, resp.Listed)
+		require.Equal(t, StatusRefused, resp.LookupStatus)
+		require.Equal(t, true, resp.LookupStatus.Failed())
+	})
+
+	t.Run("lookup_failure_error_code", func(t *testing.T) {
+
+		sentinelServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {"127.255.255.254"}}, "zen.spamhaus.org")
+		defer sentinelServer.Close()
+
+		resolver, err := NewClientResolver([]string{sentinelServer.Addr}, time.Second, 0)
+		require.Equal(t, nil, err)
+		sentinelDnsbl := NewDnsblWithResolver(config, resolver)
+		require.Empty(t, sentinelDnsbl.Warnings())
+
+		resp := sentinelDnsbl.Lookup("127.0.0.2")
+
+		//The error code is neither a listing nor scored
+		require.Equal(t, false, resp.Listed)
+		require.Equal(t, StatusRefused, resp.LookupStatus)
+		require.Equal(t, 0.0, resp.Score)
+		require.Equal(t, VerdictNeutral, resp.Verdict)
+		require.Empty(t, resp.Categories)
+		require.Equal(t, "error", resp.Responses[0].Status)
+		require.Equal(t, "blocklist domain zen.spamhaus.org answered with error code 127.255.255.254", resp.Responses[0].Msg)
+
+		sentinelDnsbl.Lookup("127.0.0.2")
+
+		warnings := sentinelDnsbl.Warnings()
+		require.Len(t, warnings, 1)
+		require.Equal(t, "zen.spamhaus.org", warnings[0].Domain)
+		require.Equal(t, "127.255.255.254", warnings[0].Code)
+		require.Equal(t, uint64(2), warnings[0].Count)
+
+		//Warnings expire once the blocklist domain stops answering with error codes
+		now := time.Now()
+		sentinelDnsbl.sentinels.now = func() time.Time { return now.Add(sentinelWarningPeriod) }
+		require.Empty(t, sentinelDnsbl.Warnings())
+	})
+
+	t.Run("lookup_success_status", func(t *testing.T) {
+
+		require.Equal(t, StatusListed, NewDnsbl(config).Lookup("127.0.0.2").LookupStatus)
+		require.Equal(t, StatusNotListed, NewDnsbl(config).Lookup("127.0.0.1").LookupStatus)
+		require.Equal(t, StatusError, NewDnsbl(config).Lookup("invalid").LookupStatus)
+
+		//A listing is certain even when another blocklist domain failed
+		require.Equal(t, StatusListed, combinedStatus([]Data{{LookupStatus: StatusTimeout}, {LookupStatus: StatusListed}}))
+		require.Equal(t, StatusTimeout, combinedStatus([]Data{{LookupStatus: StatusNotListed}, {LookupStatus: StatusTimeout}, {LookupStatus: StatusError}}))
+	})
+
+	t.Run("prefix_tree_success_range", func(t *testing.T) {
+
+		tree := &prefixTree{}
+		entry := &zoneEntry{addr: "127.0.0.2"}
+
+		//10.0.0.3 - 10.0.0.9 is covered by 10.0.0.3/32, 10.0.0.4/30 and 10.0.0.8/31
+		tree.insertRange(0x0a000003, 0x0a000009, entry)
+		require.Equal(t, 3, tree.size)
+		require.Nil(t, tree.lookup(0x0a000002))
+		require.Equal(t, entry, tree.lookup(0x0a000003))
+		require.Equal(t, entry, tree.lookup(0x0a000009))
+		require.Nil(t, tree.lookup(0x0a00000a))
+
+		tree = &prefixTree{}
+		tree.insertRange(0, 0xffffffff, entry)
+		require.Equal(t, 1, tree.size)
+		require.Equal(t, entry, tree.lookup(0xffffffff))
+	})
+
+	t.Run("load_zone_success_ip4set", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "bl.example.org.zone")
+		err := ioutil.WriteFile(path, []byte(`
+$SOA 3600 bl.example.org. hostmaster.example.org. 1 3600 600 86400 60
+# default value for the entries below
+:127.0.0.2:Listed, see https://bl.example.org/query/$
+10.0.0.0/8
+!10.1.2.3
+192.168.1.5 :3:Open proxy $
+192.168.1.6 Compromised host $
+172.16.0.1-9
+198.51
+bad.entry
+10.0-5
+`), 0644)
+		require.Equal(t, nil, err)
+
+		zone, err := LoadZone("BL.example.org.", path, "")
+		require.Equal(t, nil, err)
+		require.Equal(t, "bl.example.org", zone.Domain)
+		require.Equal(t, ZoneFormatIP4Set, zone.Format)
+
+		addr, txt, ok := zone.Lookup(net.ParseIP("10.200.0.1"))
+		require.Equal(t, true, ok)
+		require.Equal(t, "127.0.0.2", addr)
+		require.Equal(t, "Listed, see https://bl.example.org/query/10.200.0.1", txt)
+
+		_, _, ok = zone.Lookup(net.ParseIP("10.1.2.3"))
+		require.Equal(t, false, ok)
+
+		addr, txt, ok = zone.Lookup(net.ParseIP("192.168.1.5"))
+		require.Equal(t, true, ok)
+		require.Equal(t, "127.0.0.3", addr)
+		require.Equal(t, "Open proxy 192.168.1.5", txt)
+
+		//A value without a leading colon only sets the TXT record
+		addr, txt, ok = zone.Lookup(net.ParseIP("192.168.1.6"))
+		require.Equal(t, true, ok)
+		require.Equal(t, "127.0.0.2", addr)
+		require.Equal(t, "Compromised host 192.168.1.6", txt)
+
+		_, _, ok = zone.Lookup(net.ParseIP("172.16.0.9"))
+		require.Equal(t, true, ok)
+		_, _, ok = zone.Lookup(net.ParseIP("172.16.0.10"))
+		require.Equal(t, false, ok)
+		_, _, ok = zone.Lookup(net.ParseIP("198.51.100.1"))
+		require.Equal(t, true, ok)
+		_, _, ok = zone.Lookup(net.ParseIP("2001:db8::1"))
+		require.Equal(t, false, ok)
+	})
+
+	t.Run("load_zone_success_ip4tset", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "bl.example.org.zone")
+		err := ioutil.WriteFile(path, []byte(":4:\n192.0.2.1 :5:ignored\n192.0.2.0/24\n"), 0644)
+		require.Equal(t, nil, err)
+
+		zone, err := LoadZone("bl.example.org", path, ZoneFormatIP4TSet)
+		require.Equal(t, nil, err)
+
+		addr, txt, ok := zone.Lookup(net.ParseIP("192.0.2.1"))
+		require.Equal(t, true, ok)
+		require.Equal(t, "127.0.0.4", addr)
+		require.Equal(t, "", txt)
+
+		_, _, ok = zone.Lookup(net.ParseIP("192.0.2.2"))
+		require.Equal(t, false, ok)
+	})
+
+	t.Run("load_zone_failure", func(t *testing.T) {
+
+		_, err := LoadZone("bl.example.org", filepath.Join(t.TempDir(), "missing.zone"), "")
+		require.Error(t, err)
+
+		_, err = LoadZone("bl.example.org", "", "ip6set")
+		require.EqualError(t, err, "invalid zone format: ip6set")
+	})
+
+	t.Run("lookup_success_zone_file", func(t *testing.T) {
+
+		path := filepath.Join(t.TempDir(), "zen.spamhaus.org.zone")
+		err := ioutil.WriteFile(path, []byte("127.0.0.2 :2:SBL listing $\n"), 0644)
+		require.Equal(t, nil, err)
+
+		zoneConfig := withZoneFile(config, "zen.spamhaus.org", path)
+		zoneConfig.Dnsbl.AllowlistDomains = nil
+
+		//The stub resolver fails every query, so the answers must come from the zone file
+		zoneDnsbl := NewDnsblWithResolver(zoneConfig, stubResolver{})
+		defer zoneDnsbl.Stop()
+
+		resp := zoneDnsbl.Lookup("127.0.0.2")
+		require.Equal(t, "", resp.Responses[0].Msg)
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, "127.0.0.2", resp.Responses[0].Resp)
+		require.Equal(t, "SBL listing 127.0.0.2", resp.Responses[0].Reason)
+
+		resp = zoneDnsbl.Lookup("127.0.0.3")
+		require.Equal(t, "ok", resp.Responses[0].Status)
+		require.Equal(t, false, resp.Listed)
+
+		//Changes to the zone file are picked up on reload
+		err = ioutil.WriteFile(path, []byte("127.0.0.3 :3:CSS listing\n"), 0644)
+		require.Equal(t, nil, err)
+		later := time.Now().Add(time.Minute)
+		require.Equal(t, nil, os.Chtimes(path, later, later))
+
+		reloaded, err := zoneDnsbl.Zones[0].Reload()
+		require.Equal(t, nil, err)
+		require.Equal(t, true, reloaded)
+
+		resp = zoneDnsbl.Lookup("127.0.0.3")
+		require.Equal(t, true, resp.Listed)
+		require.Equal(t, []Category{{"CSS", SeverityMedium, 0.5}}, resp.Categories)
+		resp = zoneDnsbl.Lookup("127.0.0.2")
+		require.Equal(t, false, resp.Listed)
+
+		reloaded, err = zoneDnsbl.Zones[0].Reload()
+		require.Equal(t, nil, err)
+		require.Equal(t, false, reloaded)
+	})
 }
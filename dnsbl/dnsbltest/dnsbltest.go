@@ -0,0 +1,191 @@
+//Package dnsbltest provides an in-process DNSBL server for tests, so that they do
+//not depend on live queries to public blocklists
+package dnsbltest
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/egreen64/codingchallenge/utils"
+	"github.com/miekg/dns"
+)
+
+//Return codes which make the server fail the query instead of answering it
+const (
+	Timeout  = "TIMEOUT"  //The query is not answered at all
+	ServFail = "SERVFAIL" //The query is answered with SERVFAIL
+	Refused  = "REFUSED"  //The query is answered with REFUSED
+)
+
+//TTL of the answers, which is also the negative caching TTL of every zone
+const TTL = 60
+
+//Fixtures type - the return codes the server answers with, keyed by ip address.
+//Addresses missing from the fixtures are not listed.
+type Fixtures map[string][]string
+
+//SpamhausFixtures holds the answers of zen.spamhaus.org for its test addresses
+var SpamhausFixtures = Fixtures{
+	"127.0.0.2":  {"127.0.0.2"},
+	"127.0.0.3":  {"127.0.0.3"},
+	"127.0.0.4":  {"127.0.0.4"},
+	"127.0.0.9":  {"127.0.0.2", "127.0.0.9"},
+	"127.0.0.10": {"127.0.0.10"},
+	"127.0.0.11": {"127.0.0.11"},
+}
+
+//Server type - DNS server listening on a random local UDP and TCP port, answering
+//A queries for <reversed-ip>.<zone> with the return codes of the ip address and
+//TXT queries with a reason naming the ip address
+type Server struct {
+	Addr string
+
+	mu        sync.RWMutex
+	fixtures  Fixtures
+	zones     map[string]bool
+	queries   int64
+	udpServer *dns.Server
+	tcpServer *dns.Server
+}
+
+//NewServer function - starts a server answering with fixtures for the given zones,
+//or for every zone if none are given. The server must be closed with Close.
+func NewServer(fixtures Fixtures, zones ...string) *Server {
+	s := Server{
+		fixtures: Fixtures{},
+		zones:    map[string]bool{},
+	}
+	for ip, codes := range fixtures {
+		s.fixtures[utils.CanonicalIPAddress(ip)] = codes
+	}
+	for _, zone := range zones {
+		s.zones[dns.Fqdn(strings.ToLower(zone))] = true
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		panic(fmt.Sprintf("dnsbltest: unable to listen on udp: %s", err))
+	}
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		panic(fmt.Sprintf("dnsbltest: unable to listen on tcp: %s", err))
+	}
+
+	s.Addr = pc.LocalAddr().String()
+
+	udpStarted, tcpStarted := make(chan struct{}), make(chan struct{})
+	s.udpServer = &dns.Server{PacketConn: pc, Handler: &s, NotifyStartedFunc: func() { close(udpStarted) }}
+	s.tcpServer = &dns.Server{Listener: l, Handler: &s, NotifyStartedFunc: func() { close(tcpStarted) }}
+
+	go s.udpServer.ActivateAndServe()
+	go s.tcpServer.ActivateAndServe()
+	<-udpStarted
+	<-tcpStarted
+
+	return &s
+}
+
+//Close function
+func (s *Server) Close() {
+	s.udpServer.Shutdown()
+	s.tcpServer.Shutdown()
+}
+
+//SetFixture function - changes the return codes the server answers with for the ip
+//address, such as to make a failing lookup succeed when retried
+func (s *Server) SetFixture(ip string, codes []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fixtures[utils.CanonicalIPAddress(ip)] = codes
+}
+
+//Queries function - returns the number of queries received
+func (s *Server) Queries() int {
+	return int(atomic.LoadInt64(&s.queries))
+}
+
+//ServeDNS function
+func (s *Server) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	atomic.AddInt64(&s.queries, 1)
+
+	m := new(dns.Msg)
+	m.SetReply(req)
+
+	q := req.Question[0]
+	ip, zone := splitQueryName(strings.ToLower(q.Name))
+
+	var codes []string
+	if ip != nil && (len(s.zones) == 0 || s.zones[zone]) {
+		s.mu.RLock()
+		codes = s.fixtures[ip.String()]
+		s.mu.RUnlock()
+	}
+
+	switch {
+	case len(codes) == 1 && codes[0] == Timeout:
+		return
+
+	case len(codes) == 1 && codes[0] == ServFail:
+		m.Rcode = dns.RcodeServerFailure
+
+	case len(codes) == 1 && codes[0] == Refused:
+		m.Rcode = dns.RcodeRefused
+
+	case len(codes) == 0:
+		m.Rcode = dns.RcodeNameError
+		m.Ns = append(m.Ns, soa(zone))
+
+	default:
+		header := dns.RR_Header{Name: q.Name, Rrtype: q.Qtype, Class: dns.ClassINET, Ttl: TTL}
+		switch q.Qtype {
+		case dns.TypeA:
+			for _, code := range codes {
+				m.Answer = append(m.Answer, &dns.A{Hdr: header, A: net.ParseIP(code)})
+			}
+		case dns.TypeTXT:
+			m.Answer = append(m.Answer, &dns.TXT{Hdr: header, Txt: []string{fmt.Sprintf("%s is listed", ip)}})
+		default:
+			m.Ns = append(m.Ns, soa(zone))
+		}
+	}
+
+	w.WriteMsg(m)
+}
+
+//splitQueryName splits a DNSBL query name into the ip address and the zone. Names
+//starting with 32 nibbles are IPV6 queries, all others IPV4 queries.
+func splitQueryName(name string) (net.IP, string) {
+	labels := strings.Split(name, ".")
+
+	n := net.IPv4len
+	if len(labels) > 2*net.IPv6len {
+		if ip := utils.ParseReversedIP(strings.Join(labels[:2*net.IPv6len], ".")); ip != nil {
+			n = 2 * net.IPv6len
+		}
+	}
+	if len(labels) <= n {
+		return nil, name
+	}
+
+	zone := strings.Join(labels[n:], ".")
+	if zone == "" {
+		zone = "."
+	}
+	return utils.ParseReversedIP(strings.Join(labels[:n], ".")), zone
+}
+
+func soa(zone string) dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: TTL},
+		Ns:      "ns." + zone,
+		Mbox:    "hostmaster." + zone,
+		Serial:  1,
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  TTL,
+	}
+}
@@ -0,0 +1,70 @@
+package dnsbl
+
+//prefixNode type - node of a binary trie over the bits of IPV4 addresses
+type prefixNode struct {
+	children [2]*prefixNode
+	entry    *zoneEntry
+}
+
+//prefixTree type - maps IPV4 prefixes to zone entries, finding the entry of the
+//longest prefix containing an address in at most 32 steps
+type prefixTree struct {
+	root prefixNode
+	size int
+}
+
+//insert stores entry for the prefix of the given length, replacing any entry
+//already stored for exactly that prefix
+func (t *prefixTree) insert(ip uint32, length int, entry *zoneEntry) {
+	node := &t.root
+	for i := 0; i < length; i++ {
+		bit := (ip >> (31 - i)) & 1
+		if node.children[bit] == nil {
+			node.children[bit] = &prefixNode{}
+		}
+		node = node.children[bit]
+	}
+	if node.entry == nil {
+		t.size++
+	}
+	node.entry = entry
+}
+
+//insertRange stores entry for every address from first to last inclusive, as the
+//smallest set of prefixes covering the range
+func (t *prefixTree) insertRange(first uint32, last uint32, entry *zoneEntry) {
+	for {
+		length := 32
+		for length > 0 {
+			hostBits := uint(32 - length + 1)
+			mask := uint32(1)<<hostBits - 1
+			if first&mask != 0 || first|mask > last {
+				break
+			}
+			length--
+		}
+		t.insert(first, length, entry)
+
+		end := first | (uint32(1)<<uint(32-length) - 1)
+		if length == 0 || end >= last {
+			return
+		}
+		first = end + 1
+	}
+}
+
+//lookup returns the entry of the longest prefix containing ip, or nil if there is none
+func (t *prefixTree) lookup(ip uint32) *zoneEntry {
+	node := &t.root
+	entry := node.entry
+	for i := 0; i < 32; i++ {
+		node = node.children[(ip>>(31-i))&1]
+		if node == nil {
+			break
+		}
+		if node.entry != nil {
+			entry = node.entry
+		}
+	}
+	return entry
+}
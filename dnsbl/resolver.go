@@ -0,0 +1,132 @@
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	defaultQueryTimeout = 2 * time.Second
+	defaultDNSPort      = "53"
+)
+
+//Resolver interface - performs the DNS queries needed for blocklist lookups
+type Resolver interface {
+	//LookupA returns the A record addresses for host. A host that does not
+	//exist is not an error, an empty slice is returned instead.
+	LookupA(ctx context.Context, host string) ([]string, error)
+
+	//LookupTXT returns the TXT records for host, with the same handling of hosts
+	//that do not exist as LookupA
+	LookupTXT(ctx context.Context, host string) ([]string, error)
+}
+
+//NativeResolver type - Resolver built on net.Resolver
+type NativeResolver struct {
+	resolver    *net.Resolver
+	nameservers []string
+	next        uint32
+	timeout     time.Duration
+	retries     int
+}
+
+//NewNativeResolver function - Create a resolver sending queries to the given
+//upstream nameservers, or to the system resolver if none are given
+func NewNativeResolver(nameservers []string, timeout time.Duration, retries int) *NativeResolver {
+	if timeout <= 0 {
+		timeout = defaultQueryTimeout
+	}
+	if retries < 0 {
+		retries = 0
+	}
+
+	r := NativeResolver{
+		resolver: &net.Resolver{},
+		timeout:  timeout,
+		retries:  retries,
+	}
+
+	for _, ns := range nameservers {
+		if _, _, err := net.SplitHostPort(ns); err != nil {
+			ns = net.JoinHostPort(ns, defaultDNSPort)
+		}
+		r.nameservers = append(r.nameservers, ns)
+	}
+
+	if len(r.nameservers) > 0 {
+		r.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial:     r.dial,
+		}
+	}
+
+	return &r
+}
+
+//LookupA function
+func (r *NativeResolver) LookupA(ctx context.Context, host string) ([]string, error) {
+	return r.retry(ctx, host, r.lookupA)
+}
+
+//LookupTXT function
+func (r *NativeResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	return r.retry(ctx, host, r.lookupTXT)
+}
+
+//retry performs lookup until it succeeds, the host is found not to exist or all
+//retries have been used
+func (r *NativeResolver) retry(ctx context.Context, host string, lookup func(context.Context, string) ([]string, error)) ([]string, error) {
+	var err error
+	for attempt := 0; attempt <= r.retries; attempt++ {
+		var records []string
+		records, err = lookup(ctx, host)
+		if err == nil {
+			return records, nil
+		}
+
+		var dnsErr *net.DNSError
+		if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+			return []string{}, nil
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, err
+}
+
+func (r *NativeResolver) lookupA(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	ips, err := r.resolver.LookupIP(ctx, "ip4", host)
+	if err != nil {
+		return nil, err
+	}
+
+	addrs := make([]string, 0, len(ips))
+	for _, ip := range ips {
+		addrs = append(addrs, ip.String())
+	}
+	return addrs, nil
+}
+
+func (r *NativeResolver) lookupTXT(ctx context.Context, host string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+
+	return r.resolver.LookupTXT(ctx, host)
+}
+
+//dial sends each new connection to the next upstream nameserver so that
+//retries and concurrent queries are spread across all of them
+func (r *NativeResolver) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	n := atomic.AddUint32(&r.next, 1)
+	ns := r.nameservers[int(n-1)%len(r.nameservers)]
+
+	d := net.Dialer{Timeout: r.timeout}
+	return d.DialContext(ctx, network, ns)
+}
@@ -0,0 +1,144 @@
+package dnsbl
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/egreen64/codingchallenge/config"
+)
+
+//Severity type - how serious a blocklist listing is
+type Severity int
+
+//Severity values in increasing order of seriousness
+const (
+	SeverityNone Severity = iota
+	SeverityLow
+	SeverityMedium
+	SeverityHigh
+	SeverityCritical
+)
+
+var severityNames = []string{"NONE", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+//String function
+func (s Severity) String() string {
+	if s < SeverityNone || int(s) >= len(severityNames) {
+		return severityNames[SeverityNone]
+	}
+	return severityNames[s]
+}
+
+//ParseSeverity function - converts a severity name such as "HIGH" to a Severity
+func ParseSeverity(name string) (Severity, error) {
+	for i, severityName := range severityNames {
+		if strings.EqualFold(name, severityName) {
+			return Severity(i), nil
+		}
+	}
+	return SeverityNone, fmt.Errorf("invalid severity: %s", name)
+}
+
+//defaultWeight is used for blocklist domains and return codes without a configured weight
+const defaultWeight = 1.0
+
+//UnknownCategory is reported for listed return codes missing from the catalog
+var UnknownCategory = Category{Name: "UNKNOWN", Severity: SeverityMedium, Weight: defaultWeight}
+
+//Category type - named meaning of a blocklist return code
+type Category struct {
+	Name     string
+	Severity Severity
+	Weight   float64
+}
+
+//ReturnCodeCatalog type - maps the return codes of each blocklist domain to a category
+type ReturnCodeCatalog map[string]map[string]Category
+
+//defaultReturnCodes are the return codes published by Spamhaus for its blocklists,
+//see https://www.spamhaus.org/faq/section/DNSBL%20Usage#200
+var defaultReturnCodes = ReturnCodeCatalog{
+	"zen.spamhaus.org": {
+		"127.0.0.2":  {Name: "SBL", Severity: SeverityHigh, Weight: 1.0},
+		"127.0.0.3":  {Name: "CSS", Severity: SeverityMedium, Weight: 0.5},
+		"127.0.0.4":  {Name: "XBL", Severity: SeverityHigh, Weight: 1.0},
+		"127.0.0.5":  {Name: "XBL", Severity: SeverityHigh, Weight: 1.0},
+		"127.0.0.6":  {Name: "XBL", Severity: SeverityHigh, Weight: 1.0},
+		"127.0.0.7":  {Name: "XBL", Severity: SeverityHigh, Weight: 1.0},
+		"127.0.0.9":  {Name: "DROP", Severity: SeverityCritical, Weight: 2.0},
+		"127.0.0.10": {Name: "PBL-ISP", Severity: SeverityLow, Weight: 0.25},
+		"127.0.0.11": {Name: "PBL-Spamhaus", Severity: SeverityLow, Weight: 0.25},
+	},
+	"dbl.spamhaus.org": {
+		"127.0.1.2":   {Name: "DBL-SPAM", Severity: SeverityHigh, Weight: 1.0},
+		"127.0.1.4":   {Name: "DBL-PHISH", Severity: SeverityHigh, Weight: 1.0},
+		"127.0.1.5":   {Name: "DBL-MALWARE", Severity: SeverityCritical, Weight: 2.0},
+		"127.0.1.6":   {Name: "DBL-BOTNET-CC", Severity: SeverityCritical, Weight: 2.0},
+		"127.0.1.102": {Name: "DBL-ABUSED-SPAM", Severity: SeverityMedium, Weight: 0.5},
+		"127.0.1.103": {Name: "DBL-ABUSED-REDIRECTOR", Severity: SeverityMedium, Weight: 0.5},
+		"127.0.1.104": {Name: "DBL-ABUSED-PHISH", Severity: SeverityMedium, Weight: 0.5},
+		"127.0.1.105": {Name: "DBL-ABUSED-MALWARE", Severity: SeverityHigh, Weight: 1.0},
+		"127.0.1.106": {Name: "DBL-ABUSED-BOTNET-CC", Severity: SeverityHigh, Weight: 1.0},
+	},
+}
+
+//NewReturnCodeCatalog function - builds the catalog from the Spamhaus return codes
+//and the blocklists section of the config, whose return codes override the
+//Spamhaus ones code by code
+func NewReturnCodeCatalog(blocklists map[string]config.Blocklist) (ReturnCodeCatalog, error) {
+	catalog := ReturnCodeCatalog{}
+	for domain, defaultCodes := range defaultReturnCodes {
+		codes := map[string]Category{}
+		for code, category := range defaultCodes {
+			codes[code] = category
+		}
+		catalog[domain] = codes
+	}
+
+	for domain, blocklist := range blocklists {
+		codes := catalog[strings.ToLower(domain)]
+		if codes == nil {
+			codes = map[string]Category{}
+		}
+		for _, returnCode := range blocklist.ReturnCodes {
+			severity, err := ParseSeverity(returnCode.Severity)
+			if err != nil {
+				return nil, fmt.Errorf("blocklist domain %s, category %s: %s", domain, returnCode.Category, err)
+			}
+			weight := defaultWeight
+			if returnCode.Weight != nil {
+				weight = *returnCode.Weight
+			}
+			for _, code := range returnCode.Codes {
+				codes[code] = Category{Name: returnCode.Category, Severity: severity, Weight: weight}
+			}
+		}
+		catalog[strings.ToLower(domain)] = codes
+	}
+	return catalog, nil
+}
+
+//Decode function - returns the categories for the addresses a blocklist domain answered with
+func (c ReturnCodeCatalog) Decode(domain string, addrs []string) []Category {
+	codes := c[strings.ToLower(domain)]
+
+	var categories []Category
+	for _, addr := range addrs {
+		category, ok := codes[addr]
+		if !ok {
+			category = UnknownCategory
+		}
+		categories = appendCategory(categories, category)
+	}
+	return categories
+}
+
+//appendCategory appends category unless a category with the same name is already present
+func appendCategory(categories []Category, category Category) []Category {
+	for _, c := range categories {
+		if c.Name == category.Name {
+			return categories
+		}
+	}
+	return append(categories, category)
+}
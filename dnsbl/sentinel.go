@@ -0,0 +1,117 @@
+package dnsbl
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/egreen64/codingchallenge/config"
+)
+
+//sentinelWarningPeriod is how long an error code answered by a blocklist domain is
+//reported as a health warning
+const sentinelWarningPeriod = 15 * time.Minute
+
+//ErrorCodeError type - blocklist domain answered with one of its error codes, such as
+//the 127.255.255.254 Spamhaus returns for queries sent through a public resolver,
+//instead of a listing
+type ErrorCodeError struct {
+	Domain string
+	Code   string
+}
+
+//Error function
+func (e *ErrorCodeError) Error() string {
+	return fmt.Sprintf("blocklist domain %s answered with error code %s", e.Domain, e.Code)
+}
+
+//SentinelWarning type - error code answered by a blocklist domain
+type SentinelWarning struct {
+	Domain   string    `json:"blocklist_domain"`
+	Code     string    `json:"error_code"`
+	Count    uint64    `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+//ErrorCodeCatalog type - the error codes of each blocklist domain
+type ErrorCodeCatalog map[string]map[string]bool
+
+//NewErrorCodeCatalog function - builds the catalog from the blocklists section of the config
+func NewErrorCodeCatalog(blocklists map[string]config.Blocklist) ErrorCodeCatalog {
+	catalog := ErrorCodeCatalog{}
+	for domain, blocklist := range blocklists {
+		if len(blocklist.ErrorCodes) == 0 {
+			continue
+		}
+		codes := map[string]bool{}
+		for _, code := range blocklist.ErrorCodes {
+			codes[code] = true
+		}
+		catalog[strings.ToLower(domain)] = codes
+	}
+	return catalog
+}
+
+//Find function - returns the first of the addresses a blocklist domain answered with
+//that is one of its error codes
+func (c ErrorCodeCatalog) Find(domain string, addrs []string) (string, bool) {
+	codes := c[strings.ToLower(domain)]
+	for _, addr := range addrs {
+		if codes[addr] {
+			return addr, true
+		}
+	}
+	return "", false
+}
+
+//sentinelMonitor type - records the error codes answered by each blocklist domain
+type sentinelMonitor struct {
+	mu       sync.Mutex
+	warnings map[string]*SentinelWarning
+	now      func() time.Time
+}
+
+func newSentinelMonitor() *sentinelMonitor {
+	return &sentinelMonitor{
+		warnings: map[string]*SentinelWarning{},
+		now:      time.Now,
+	}
+}
+
+func (m *sentinelMonitor) record(domain string, code string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := domain + " " + code
+	warning, ok := m.warnings[key]
+	if !ok {
+		warning = &SentinelWarning{Domain: domain, Code: code}
+		m.warnings[key] = warning
+	}
+	warning.Count++
+	warning.LastSeen = m.now()
+}
+
+//active returns the error codes answered within the last sentinelWarningPeriod,
+//ordered by blocklist domain and error code
+func (m *sentinelMonitor) active() []SentinelWarning {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	since := m.now().Add(-sentinelWarningPeriod)
+	warnings := []SentinelWarning{}
+	for _, warning := range m.warnings {
+		if warning.LastSeen.After(since) {
+			warnings = append(warnings, *warning)
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool {
+		if warnings[i].Domain != warnings[j].Domain {
+			return warnings[i].Domain < warnings[j].Domain
+		}
+		return warnings[i].Code < warnings[j].Code
+	})
+	return warnings
+}
@@ -0,0 +1,63 @@
+package dnsbl
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+//LookupStatus type - outcome of looking up an ip address or domain
+type LookupStatus string
+
+//LookupStatus values
+const (
+	StatusListed    LookupStatus = "LISTED"
+	StatusNotListed LookupStatus = "NOT_LISTED"
+	StatusError     LookupStatus = "ERROR"
+	StatusTimeout   LookupStatus = "TIMEOUT"
+	StatusRefused   LookupStatus = "REFUSED"
+)
+
+//Failed function - true if the lookup did not produce a result
+func (s LookupStatus) Failed() bool {
+	return s != StatusListed && s != StatusNotListed
+}
+
+//errorStatus classifies a failed query
+func errorStatus(err error) LookupStatus {
+	var queryErr *QueryError
+	if errors.As(err, &queryErr) && queryErr.Rcode == dns.RcodeRefused {
+		return StatusRefused
+	}
+
+	var errorCodeErr *ErrorCodeError
+	if errors.As(err, &errorCodeErr) {
+		return StatusRefused
+	}
+
+	var netErr net.Error
+	if (errors.As(err, &netErr) && netErr.Timeout()) || errors.Is(err, context.DeadlineExceeded) {
+		return StatusTimeout
+	}
+
+	return StatusError
+}
+
+//combinedStatus returns LISTED if any of the responses is listed, otherwise the
+//status of the first failed response, otherwise NOT_LISTED. A failure to query
+//one blocklist domain does not make a listing on another any less certain, but
+//does make a missing listing uncertain.
+func combinedStatus(responses []Data) LookupStatus {
+	status := StatusNotListed
+	for _, data := range responses {
+		switch {
+		case data.LookupStatus == StatusListed:
+			return StatusListed
+		case data.LookupStatus.Failed() && status == StatusNotListed:
+			status = data.LookupStatus
+		}
+	}
+	return status
+}
@@ -0,0 +1,67 @@
+package dnsbl
+
+import "fmt"
+
+//Verdict type - combined result of the blocklist and allowlist lookups
+type Verdict string
+
+//Verdict values
+const (
+	VerdictAllow   Verdict = "ALLOW"
+	VerdictBlock   Verdict = "BLOCK"
+	VerdictNeutral Verdict = "NEUTRAL"
+)
+
+//VerdictPolicy type - decides the verdict for an address listed on both a
+//blocklist and an allowlist
+type VerdictPolicy string
+
+//VerdictPolicy values
+const (
+	PolicyAllowlistWins VerdictPolicy = "allowlist_wins"
+	PolicyBlocklistWins VerdictPolicy = "blocklist_wins"
+	PolicyScore         VerdictPolicy = "score"
+)
+
+//ParseVerdictPolicy function - an empty name selects PolicyBlocklistWins
+func ParseVerdictPolicy(name string) (VerdictPolicy, error) {
+	switch policy := VerdictPolicy(name); policy {
+	case "":
+		return PolicyBlocklistWins, nil
+	case PolicyAllowlistWins, PolicyBlocklistWins, PolicyScore:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid verdict policy: %s", name)
+	}
+}
+
+//Verdict function - an address listed on neither kind of list is NEUTRAL and an
+//address listed on only one kind is BLOCK or ALLOW. The policy only decides
+//between addresses listed on both. With PolicyScore a positive weighted score
+//is BLOCK, a negative one ALLOW and a score of zero NEUTRAL.
+func (p VerdictPolicy) Verdict(resp Return) Verdict {
+	switch {
+	case !resp.Listed && !resp.Allowlisted:
+		return VerdictNeutral
+	case !resp.Allowlisted:
+		return VerdictBlock
+	case !resp.Listed:
+		return VerdictAllow
+	}
+
+	switch p {
+	case PolicyAllowlistWins:
+		return VerdictAllow
+	case PolicyScore:
+		switch {
+		case resp.Score > 0:
+			return VerdictBlock
+		case resp.Score < 0:
+			return VerdictAllow
+		default:
+			return VerdictNeutral
+		}
+	default:
+		return VerdictBlock
+	}
+}
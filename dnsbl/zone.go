@@ -0,0 +1,385 @@
+package dnsbl
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Zone file formats, as described in the rbldnsd manual
+const (
+	ZoneFormatIP4Set  = "ip4set"
+	ZoneFormatIP4TSet = "ip4tset"
+)
+
+//defaultZoneValue is the answer for listed addresses when the zone file sets none
+const defaultZoneValue = "127.0.0.2"
+
+//zoneEntry type - answer for the addresses of one or more zone file entries
+type zoneEntry struct {
+	addr     string
+	txt      string
+	excluded bool
+}
+
+//Zone type - a local copy of a blocklist held in an rbldnsd zone file
+type Zone struct {
+	Domain string
+	Path   string
+	Format string
+
+	mu      sync.RWMutex
+	tree    *prefixTree
+	modTime time.Time
+}
+
+//LoadZone function - reads the zone file at path for the blocklist domain
+func LoadZone(domain string, path string, format string) (*Zone, error) {
+	if format == "" {
+		format = ZoneFormatIP4Set
+	}
+	if format != ZoneFormatIP4Set && format != ZoneFormatIP4TSet {
+		return nil, fmt.Errorf("invalid zone format: %s", format)
+	}
+
+	zone := Zone{
+		Domain: strings.TrimSuffix(strings.ToLower(domain), "."),
+		Path:   path,
+		Format: format,
+	}
+
+	if _, err := zone.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &zone, nil
+}
+
+//Reload function - reads the zone file again if it changed since it was last
+//read, returning whether it was read. The previous data is kept if reading fails.
+func (z *Zone) Reload() (bool, error) {
+	info, err := os.Stat(z.Path)
+	if err != nil {
+		return false, err
+	}
+
+	z.mu.RLock()
+	unchanged := z.tree != nil && info.ModTime().Equal(z.modTime)
+	z.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	tree, err := z.read()
+	if err != nil {
+		return false, err
+	}
+
+	z.mu.Lock()
+	z.tree = tree
+	z.modTime = info.ModTime()
+	z.mu.Unlock()
+
+	log.Printf("loaded %d entries for blocklist domain %s from zone file %s\n", tree.size, z.Domain, z.Path)
+
+	return true, nil
+}
+
+//Lookup function - returns the A and TXT records for ip, or false if ip is not listed
+func (z *Zone) Lookup(ip net.IP) (string, string, bool) {
+	ip4 := ip.To4()
+	if ip4 == nil {
+		return "", "", false
+	}
+
+	z.mu.RLock()
+	entry := z.tree.lookup(binary.BigEndian.Uint32(ip4))
+	z.mu.RUnlock()
+
+	if entry == nil || entry.excluded {
+		return "", "", false
+	}
+	return entry.addr, strings.Replace(entry.txt, "$", ip4.String(), -1), true
+}
+
+//read parses the zone file into a new prefix tree. Lines that cannot be parsed
+//are logged and skipped, as rbldnsd does.
+func (z *Zone) read() (*prefixTree, error) {
+	file, err := os.Open(z.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer file.Close()
+
+	tree := &prefixTree{}
+	defaultEntry := &zoneEntry{addr: defaultZoneValue}
+	excludedEntry := &zoneEntry{excluded: true}
+
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';' || line[0] == '$':
+			//Comments and directives such as $SOA and $TTL are not needed for local lookups
+			continue
+
+		case line[0] == ':':
+			entry, err := parseZoneValue(line, defaultEntry)
+			if err != nil {
+				log.Printf("zone file %s line %d: %s\n", z.Path, lineNumber, err)
+				continue
+			}
+			defaultEntry = entry
+			continue
+		}
+
+		key, value := line, ""
+		if i := strings.IndexAny(line, " \t"); i >= 0 {
+			key, value = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		entry := defaultEntry
+		if strings.HasPrefix(key, "!") {
+			entry = excludedEntry
+			key = key[1:]
+		} else if value != "" && z.Format == ZoneFormatIP4Set {
+			entry, err = parseZoneValue(value, defaultEntry)
+			if err != nil {
+				log.Printf("zone file %s line %d: %s\n", z.Path, lineNumber, err)
+				continue
+			}
+		}
+
+		//ip4tset zones only contain single addresses, all sharing the default value
+		if z.Format == ZoneFormatIP4TSet {
+			ip := net.ParseIP(key).To4()
+			if ip == nil {
+				log.Printf("zone file %s line %d: invalid ip address: %s\n", z.Path, lineNumber, key)
+				continue
+			}
+			tree.insert(binary.BigEndian.Uint32(ip), 32, entry)
+			continue
+		}
+
+		first, last, err := parseIP4Range(key)
+		if err != nil {
+			log.Printf("zone file %s line %d: %s\n", z.Path, lineNumber, err)
+			continue
+		}
+		tree.insertRange(first, last, entry)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return tree, nil
+}
+
+//parseZoneValue parses an A and TXT value such as ":127.0.0.2:Listed, see $" or
+//":3:". The A value may be given as a single number N meaning 127.0.0.N and
+//either part may be left empty to use that of defaultEntry. A value not starting
+//with a colon is the TXT value alone, such as "Listed, see $".
+func parseZoneValue(value string, defaultEntry *zoneEntry) (*zoneEntry, error) {
+	entry := zoneEntry{addr: defaultEntry.addr, txt: defaultEntry.txt}
+	if !strings.HasPrefix(value, ":") {
+		entry.txt = value
+		return &entry, nil
+	}
+
+	value = value[1:]
+	parts := strings.SplitN(value, ":", 2)
+
+	if addr := strings.TrimSpace(parts[0]); addr != "" {
+		if n, err := strconv.Atoi(addr); err == nil && n >= 0 && n <= 255 {
+			addr = fmt.Sprintf("127.0.0.%d", n)
+		} else if ip := net.ParseIP(addr).To4(); ip != nil {
+			addr = ip.String()
+		} else {
+			return nil, fmt.Errorf("invalid zone value: %s", value)
+		}
+		entry.addr = addr
+	}
+	if len(parts) > 1 {
+		entry.txt = parts[1]
+	}
+
+	return &entry, nil
+}
+
+//parseIP4Range parses an ip4set entry, which is a single address, a CIDR prefix
+//such as 10.0.0.0/8, an incomplete address such as 10.0 meaning 10.0.0.0/16, or
+//a range such as 10.0.0.1-10.0.0.9 or its shorthand 10.0.0.1-9
+func parseIP4Range(value string) (uint32, uint32, error) {
+	if i := strings.Index(value, "-"); i >= 0 {
+		first, _, err := parseIP4Prefix(value[:i])
+		if err != nil {
+			return 0, 0, err
+		}
+
+		lastValue := value[i+1:]
+		if n := strings.Count(lastValue, "."); n < 3 {
+			//The missing leading octets are those of the first address
+			octets := strings.Split(value[:i], ".")
+			if len(octets) < 3-n {
+				return 0, 0, fmt.Errorf("invalid ip address range: %s", value)
+			}
+			lastValue = strings.Join(append(octets[:3-n], lastValue), ".")
+		}
+		last, _, err := parseIP4Prefix(lastValue)
+		if err != nil || last < first {
+			return 0, 0, fmt.Errorf("invalid ip address range: %s", value)
+		}
+		return first, last, nil
+	}
+
+	ip, length, err := parseIP4Prefix(value)
+	if err != nil {
+		return 0, 0, err
+	}
+	hostMask := uint32(1)<<uint(32-length) - 1
+	if length == 0 {
+		hostMask = ^uint32(0)
+	}
+	return ip &^ hostMask, ip | hostMask, nil
+}
+
+//parseIP4Prefix parses an address, CIDR prefix or incomplete address into the
+//address and the length of its prefix
+func parseIP4Prefix(value string) (uint32, int, error) {
+	length := -1
+	if i := strings.Index(value, "/"); i >= 0 {
+		n, err := strconv.Atoi(value[i+1:])
+		if err != nil || n < 0 || n > 32 {
+			return 0, 0, fmt.Errorf("invalid ip address prefix: %s", value)
+		}
+		length, value = n, value[:i]
+	}
+
+	octets := strings.Split(value, ".")
+	if len(octets) > 4 {
+		return 0, 0, fmt.Errorf("invalid ip address: %s", value)
+	}
+	if length < 0 {
+		length = 8 * len(octets)
+	}
+
+	var ip uint32
+	for i := 0; i < 4; i++ {
+		var octet int
+		if i < len(octets) {
+			var err error
+			octet, err = strconv.Atoi(octets[i])
+			if err != nil || octet < 0 || octet > 255 {
+				return 0, 0, fmt.Errorf("invalid ip address: %s", value)
+			}
+		}
+		ip = ip<<8 | uint32(octet)
+	}
+
+	return ip, length, nil
+}
+
+//ZoneResolver type - Resolver answering queries for the blocklist domains it has
+//a zone for from the zone, without any network traffic, and passing all other
+//queries on to resolver
+type ZoneResolver struct {
+	resolver    Resolver
+	zones       map[string]*Zone
+	stopChannel chan struct{}
+}
+
+//NewZoneResolver function
+func NewZoneResolver(resolver Resolver, zones []*Zone) *ZoneResolver {
+	r := ZoneResolver{
+		resolver:    resolver,
+		zones:       map[string]*Zone{},
+		stopChannel: make(chan struct{}),
+	}
+	for _, zone := range zones {
+		r.zones[zone.Domain] = zone
+	}
+	return &r
+}
+
+//LookupA function
+func (r *ZoneResolver) LookupA(ctx context.Context, host string) ([]string, error) {
+	zone, ip := r.zone(host)
+	if zone == nil {
+		return r.resolver.LookupA(ctx, host)
+	}
+
+	if addr, _, ok := zone.Lookup(ip); ok {
+		return []string{addr}, nil
+	}
+	return []string{}, nil
+}
+
+//LookupTXT function
+func (r *ZoneResolver) LookupTXT(ctx context.Context, host string) ([]string, error) {
+	zone, ip := r.zone(host)
+	if zone == nil {
+		return r.resolver.LookupTXT(ctx, host)
+	}
+
+	if _, txt, ok := zone.Lookup(ip); ok && txt != "" {
+		return []string{txt}, nil
+	}
+	return []string{}, nil
+}
+
+//Reload function - reloads the zone files that changed on disk
+func (r *ZoneResolver) Reload() {
+	for _, zone := range r.zones {
+		if _, err := zone.Reload(); err != nil {
+			log.Printf("unable to reload zone file %s for blocklist domain %s, error: %s\n", zone.Path, zone.Domain, err)
+		}
+	}
+}
+
+//Stop function - stops reloading the zone files
+func (r *ZoneResolver) Stop() {
+	close(r.stopChannel)
+}
+
+//watch reloads the zone files that changed on disk every interval until stopped
+func (r *ZoneResolver) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			r.Reload()
+		case <-r.stopChannel:
+			return
+		}
+	}
+}
+
+//zone returns the zone holding host, which is a reversed IPV4 address followed
+//by the blocklist domain, and the address. Reversed addresses that cannot be
+//parsed are returned as nil, which no zone lists.
+func (r *ZoneResolver) zone(host string) (*Zone, net.IP) {
+	labels := strings.SplitN(strings.TrimSuffix(strings.ToLower(host), "."), ".", 5)
+	if len(labels) < 5 {
+		return nil, nil
+	}
+
+	zone, ok := r.zones[labels[4]]
+	if !ok {
+		return nil, nil
+	}
+
+	return zone, net.ParseIP(fmt.Sprintf("%s.%s.%s.%s", labels[3], labels[2], labels[1], labels[0]))
+}
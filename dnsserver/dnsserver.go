@@ -0,0 +1,191 @@
+package dnsserver
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/egreen64/codingchallenge/config"
+	"github.com/egreen64/codingchallenge/db"
+	"github.com/egreen64/codingchallenge/utils"
+	"github.com/miekg/dns"
+)
+
+const (
+	defaultListenAddress = ":5353"
+	defaultTTL           = 300
+)
+
+//DNSServer type - answers DNSBL queries for the ip addresses stored in the database
+//so that mail servers can use it as a blocklist domain
+type DNSServer struct {
+	db        *db.Database
+	zone      string
+	ttl       uint32
+	address   string
+	udpServer *dns.Server
+	tcpServer *dns.Server
+}
+
+//NewDNSServer function
+func NewDNSServer(config *config.File, db *db.Database) *DNSServer {
+	dnsServer := DNSServer{
+		db:      db,
+		zone:    dns.Fqdn(strings.ToLower(config.DNSServer.Zone)),
+		ttl:     uint32(config.DNSServer.TTL),
+		address: config.DNSServer.ListenAddress,
+	}
+	if dnsServer.address == "" {
+		dnsServer.address = defaultListenAddress
+	}
+	if config.DNSServer.TTL <= 0 {
+		dnsServer.ttl = defaultTTL
+	}
+
+	return &dnsServer
+}
+
+//Start function - listens for queries over UDP and TCP on the same port
+func (s *DNSServer) Start() error {
+	pc, err := net.ListenPacket("udp", s.address)
+	if err != nil {
+		return fmt.Errorf("unable to listen on udp address %s: %s", s.address, err)
+	}
+
+	//Listen on the port chosen for UDP, should the configured port be 0
+	l, err := net.Listen("tcp", pc.LocalAddr().String())
+	if err != nil {
+		pc.Close()
+		return fmt.Errorf("unable to listen on tcp address %s: %s", s.address, err)
+	}
+
+	s.udpServer = &dns.Server{PacketConn: pc, Handler: s}
+	s.tcpServer = &dns.Server{Listener: l, Handler: s}
+
+	go s.serve(s.udpServer)
+	go s.serve(s.tcpServer)
+
+	log.Printf("dns server listening on %s for zone %s\n", pc.LocalAddr(), s.zone)
+
+	return nil
+}
+
+//Addr function - returns the address the server is listening on
+func (s *DNSServer) Addr() string {
+	return s.udpServer.PacketConn.LocalAddr().String()
+}
+
+//Stop function
+func (s *DNSServer) Stop() {
+	log.Println("stopping dns server")
+	s.udpServer.Shutdown()
+	s.tcpServer.Shutdown()
+	log.Println("dns server stopped")
+}
+
+func (s *DNSServer) serve(server *dns.Server) {
+	err := server.ActivateAndServe()
+	if err != nil {
+		log.Printf("dns server error: %s\n", err)
+	}
+}
+
+//ServeDNS function - answers a query for <reversed-ip>.<zone> with the response code
+//of the stored record as an A record and its reason as a TXT record. Addresses
+//without a listed record do not exist in the zone.
+func (s *DNSServer) ServeDNS(w dns.ResponseWriter, req *dns.Msg) {
+	m := new(dns.Msg)
+	m.SetReply(req)
+	m.Authoritative = true
+
+	if len(req.Question) != 1 {
+		m.SetRcode(req, dns.RcodeFormatError)
+		w.WriteMsg(m)
+		return
+	}
+
+	q := req.Question[0]
+	name := strings.ToLower(q.Name)
+
+	switch {
+	case name == s.zone:
+		if q.Qtype == dns.TypeSOA {
+			m.Answer = append(m.Answer, s.soa())
+		} else {
+			m.Ns = append(m.Ns, s.soa())
+		}
+
+	case strings.HasSuffix(name, "."+s.zone):
+		s.answer(m, q, strings.TrimSuffix(name, "."+s.zone))
+
+	default:
+		m.SetRcode(req, dns.RcodeRefused)
+	}
+
+	w.WriteMsg(m)
+}
+
+func (s *DNSServer) answer(m *dns.Msg, q dns.Question, reversed string) {
+	ip := utils.ParseReversedIP(reversed)
+	if ip == nil {
+		s.nameError(m)
+		return
+	}
+
+	record, err := s.db.SelectListing(ip.String())
+	if err != nil || record == nil || !record.Listed {
+		s.nameError(m)
+		return
+	}
+
+	addr := net.ParseIP(record.ResponseCode).To4()
+	if addr == nil {
+		s.nameError(m)
+		return
+	}
+
+	header := dns.RR_Header{Name: q.Name, Class: dns.ClassINET, Ttl: s.ttl}
+	switch {
+	case q.Qtype == dns.TypeA || q.Qtype == dns.TypeANY:
+		header.Rrtype = dns.TypeA
+		m.Answer = append(m.Answer, &dns.A{Hdr: header, A: addr})
+	case q.Qtype == dns.TypeTXT && record.Reason != nil:
+		header.Rrtype = dns.TypeTXT
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: header, Txt: splitTXT(*record.Reason)})
+	default:
+		m.Ns = append(m.Ns, s.soa())
+	}
+}
+
+//soa returns the SOA record of the zone, whose minimum is also the TTL of negative answers
+func (s *DNSServer) soa() dns.RR {
+	return &dns.SOA{
+		Hdr:     dns.RR_Header{Name: s.zone, Rrtype: dns.TypeSOA, Class: dns.ClassINET, Ttl: s.ttl},
+		Ns:      s.zone,
+		Mbox:    "hostmaster." + s.zone,
+		Serial:  uint32(time.Now().Unix()),
+		Refresh: 3600,
+		Retry:   600,
+		Expire:  86400,
+		Minttl:  s.ttl,
+	}
+}
+
+//nameError answers that the queried name does not exist, which is how a DNSBL
+//reports that an address is not listed
+func (s *DNSServer) nameError(m *dns.Msg) {
+	m.Rcode = dns.RcodeNameError
+	m.Ns = append(m.Ns, s.soa())
+}
+
+//splitTXT splits text into the strings of at most 255 bytes a TXT record is made of
+func splitTXT(text string) []string {
+	var txt []string
+	for len(text) > 255 {
+		txt = append(txt, text[:255])
+		text = text[255:]
+	}
+	return append(txt, text)
+}
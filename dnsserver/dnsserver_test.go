@@ -0,0 +1,124 @@
+package dnsserver
+
+import (
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+
+	"github.com/egreen64/codingchallenge/config"
+	"github.com/egreen64/codingchallenge/db"
+	"github.com/egreen64/codingchallenge/graph/model"
+	"github.com/google/uuid"
+	"github.com/miekg/dns"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDNSServer(t *testing.T) {
+	//Disable logging
+	log.SetOutput(ioutil.Discard)
+
+	//Set location of config file
+	os.Setenv("GO_CONFIG", "../config.json")
+
+	//Get config file
+	config := config.GetConfig()
+	config.DNSServer.ListenAddress = "127.0.0.1:0"
+	config.DNSServer.Zone = "bl.example.org"
+
+	//Delete database
+	os.Remove(config.Database.DbPath)
+
+	database := db.NewDatabase(config)
+	defer os.Remove(config.Database.DbPath)
+	defer database.CloseDatabase()
+
+	reason := "https://www.spamhaus.org/query/ip/127.0.0.2"
+	records := []model.DNSBlockListRecord{
+		{UUID: uuid.New().String(), IPAddress: "127.0.0.2", ResponseCode: "127.0.0.2", Listed: true, Reason: &reason},
+		{UUID: uuid.New().String(), IPAddress: "127.0.0.1", ResponseCode: "NXDOMAIN"},
+		{UUID: uuid.New().String(), IPAddress: "2001:db8::1", ResponseCode: "127.0.0.3", Listed: true},
+	}
+	for i := range records {
+		require.Equal(t, nil, database.UpsertRecord(&records[i]))
+	}
+
+	dnsServer := NewDNSServer(config, database)
+	require.Equal(t, nil, dnsServer.Start())
+	defer dnsServer.Stop()
+
+	query := func(t *testing.T, net string, name string, qtype uint16) *dns.Msg {
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, qtype)
+
+		client := dns.Client{Net: net}
+		in, _, err := client.Exchange(msg, dnsServer.Addr())
+		require.Equal(t, nil, err)
+		return in
+	}
+
+	t.Run("query_a_success_listed", func(t *testing.T) {
+
+		in := query(t, "udp", "2.0.0.127.bl.example.org.", dns.TypeA)
+		require.Equal(t, dns.RcodeSuccess, in.Rcode)
+		require.Len(t, in.Answer, 1)
+		require.Equal(t, "127.0.0.2", in.Answer[0].(*dns.A).A.String())
+		require.Equal(t, uint32(300), in.Answer[0].Header().Ttl)
+	})
+
+	t.Run("query_txt_success_listed", func(t *testing.T) {
+
+		in := query(t, "tcp", "2.0.0.127.BL.example.org.", dns.TypeTXT)
+		require.Equal(t, dns.RcodeSuccess, in.Rcode)
+		require.Len(t, in.Answer, 1)
+		require.Equal(t, []string{reason}, in.Answer[0].(*dns.TXT).Txt)
+	})
+
+	t.Run("query_txt_success_no_reason", func(t *testing.T) {
+
+		in := query(t, "udp", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.bl.example.org.", dns.TypeTXT)
+		require.Equal(t, dns.RcodeSuccess, in.Rcode)
+		require.Len(t, in.Answer, 0)
+		require.Len(t, in.Ns, 1)
+	})
+
+	t.Run("query_a_success_ipv6_listed", func(t *testing.T) {
+
+		in := query(t, "udp", "1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2.bl.example.org.", dns.TypeA)
+		require.Equal(t, dns.RcodeSuccess, in.Rcode)
+		require.Equal(t, "127.0.0.3", in.Answer[0].(*dns.A).A.String())
+	})
+
+	t.Run("query_a_failure_not_listed", func(t *testing.T) {
+
+		in := query(t, "udp", "1.0.0.127.bl.example.org.", dns.TypeA)
+		require.Equal(t, dns.RcodeNameError, in.Rcode)
+		require.Len(t, in.Ns, 1)
+		require.Equal(t, uint32(300), in.Ns[0].(*dns.SOA).Minttl)
+
+		in = query(t, "udp", "3.0.0.127.bl.example.org.", dns.TypeA)
+		require.Equal(t, dns.RcodeNameError, in.Rcode)
+	})
+
+	t.Run("query_a_failure_invalid_ip_address", func(t *testing.T) {
+
+		in := query(t, "udp", "300.0.0.127.bl.example.org.", dns.TypeA)
+		require.Equal(t, dns.RcodeNameError, in.Rcode)
+
+		in = query(t, "udp", "0.127.bl.example.org.", dns.TypeA)
+		require.Equal(t, dns.RcodeNameError, in.Rcode)
+	})
+
+	t.Run("query_soa_success", func(t *testing.T) {
+
+		in := query(t, "udp", "bl.example.org.", dns.TypeSOA)
+		require.Equal(t, dns.RcodeSuccess, in.Rcode)
+		require.Equal(t, "bl.example.org.", in.Answer[0].(*dns.SOA).Hdr.Name)
+	})
+
+	t.Run("query_failure_refused", func(t *testing.T) {
+
+		in := query(t, "udp", "2.0.0.127.zen.spamhaus.org.", dns.TypeA)
+		require.Equal(t, dns.RcodeRefused, in.Rcode)
+	})
+}
@@ -8,7 +8,8 @@ require (
 	github.com/go-chi/chi v3.3.2+incompatible
 	github.com/google/uuid v1.1.2
 	github.com/mattn/go-sqlite3 v1.14.4
-	github.com/nerdbaggy/godnsbl v0.0.0-20160202203746-2bc56da342c6
+	github.com/miekg/dns v1.1.35
 	github.com/stretchr/testify v1.4.0
 	github.com/vektah/gqlparser/v2 v2.1.0
+	golang.org/x/net v0.0.0-20201021035429-f5854403a974
 )
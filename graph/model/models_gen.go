@@ -3,6 +3,9 @@
 package model
 
 import (
+	"fmt"
+	"io"
+	"strconv"
 	"time"
 )
 
@@ -14,7 +17,26 @@ type AuthToken struct {
 	BearerToken string `json:"bearer_token"`
 }
 
-// Contains information about whether or not an IPV4 address is on a blocklist
+// Contains the result of looking up an ip address on a single blocklist or allowlist domain
+type BlocklistListing struct {
+	// Blocklist or allowlist domain that was queried
+	BlocklistDomain string `json:"blocklist_domain"`
+	// Indicates if blocklist_domain is an allowlist (DNSWL) rather than a blocklist
+	Allowlist bool `json:"allowlist"`
+	// Indicates if the ip address is listed on the blocklist domain
+	Listed bool `json:"listed"`
+	// Outcome of the last query of the blocklist domain that produced a result
+	LookupStatus LookupStatus `json:"lookup_status"`
+	// Response code returned by the blocklist domain, "NXDOMAIN" if the ip address is not listed, or the lookup_status
+	// if the query failed
+	ResponseCode string `json:"response_code"`
+	// Explanation published by the blocklist domain in a TXT record for the listing, or null if there is none
+	Reason *string `json:"reason"`
+	// Timestamp indicating when the blocklist domain was last queried
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Contains information about whether or not an IPV4 or IPV6 address is on a blocklist
 type DNSBlockListRecord struct {
 	// A unique identifier generated by the system for each record
 	UUID string `json:"uuid"`
@@ -25,6 +47,510 @@ type DNSBlockListRecord struct {
 	// Indicates if the ip_address is on the blocklist. For detailed information on the response code values,
 	// you can refer to https://www.spamhaus.org/faq/section/DNSBL%20Usage#200
 	ResponseCode string `json:"response_code"`
-	// IPV4 address of the record
+	// IPV4 or IPV6 address of the record, in canonical form (e.g. 2001:db8::1)
 	IPAddress string `json:"ip_address"`
+	// Indicates if the ip_address is listed on any of the blocklist domains
+	Listed bool `json:"listed"`
+	// Outcome of the last lookup that produced a result. Failed lookups never replace a previous result, so
+	// ERROR, TIMEOUT and REFUSED are only reported while the ip_address has not yet been looked up successfully
+	LookupStatus LookupStatus `json:"lookup_status"`
+	// Combined verdict of the blocklist and allowlist listings, decided by the configured verdict policy
+	// when the ip_address is listed on both a blocklist and an allowlist
+	Verdict Verdict `json:"verdict"`
+	// Weighted reputation score: the sum over the listing blocklist domains of the blocklist weight multiplied by the highest
+	// weight of its response codes, less the same sum over the listing allowlist domains. Recomputed on every refresh
+	Score float64 `json:"score"`
+	// Indicates if the score reached the configured score threshold
+	Blocked bool `json:"blocked"`
+	// Categories decoded from the response codes of every blocklist domain listing the ip_address, e.g. SBL, XBL or PBL-ISP
+	Categories []string `json:"categories"`
+	// Highest severity of all the categories
+	Severity Severity `json:"severity"`
+	// Explanation published by the blocklist domain in a TXT record for the listing the response_code was taken from,
+	// usually including a link to a removal page. Null if the ip_address is not listed or no TXT record was published
+	Reason *string `json:"reason"`
+	// Result of the lookup on each configured blocklist and allowlist domain, including the domains on which the ip_address
+	// is not listed. IPV6 addresses are only looked up on the domains that support IPV6
+	Listings []*BlocklistListing `json:"listings"`
+	// Results of the lookups of the ip_address that differed from the result before them, most recent first
+	History []*RecordChange `json:"history"`
+}
+
+// Contains information about whether or not a domain is on a domain blocklist (RHSBL) such as dbl.spamhaus.org
+type DNSDomainBlockListRecord struct {
+	// A unique identifier generated by the system for each record
+	UUID string `json:"uuid"`
+	// Timestamp indicating when the record was first created
+	CreatedAt time.Time `json:"created_at"`
+	// Timestamp indicating when the record was last updated
+	UpdatedAt time.Time `json:"updated_at"`
+	// Indicates if the domain is on the blocklist. For detailed information on the response code values,
+	// you can refer to https://www.spamhaus.org/faq/section/Spamhaus%20DBL#291
+	ResponseCode string `json:"response_code"`
+	// Domain of the record, in lower case IDNA (punycode) form
+	Domain string `json:"domain"`
+	// Indicates if the domain is listed on any of the domain blocklist domains
+	Listed bool `json:"listed"`
+	// Outcome of the last lookup that produced a result. Failed lookups never replace a previous result
+	LookupStatus LookupStatus `json:"lookup_status"`
+	// Categories decoded from the response codes of every domain blocklist domain listing the domain, e.g. DBL-SPAM
+	Categories []string `json:"categories"`
+	// Highest severity of all the categories
+	Severity Severity `json:"severity"`
+	// Explanation published by the domain blocklist domain in a TXT record for the listing the response_code was taken from
+	Reason *string `json:"reason"`
+	// Weighted reputation score: the sum over the listing domain blocklist domains of the blocklist weight multiplied by
+	// the highest weight of its response codes
+	Score float64 `json:"score"`
+	// Indicates if the score reached the configured score threshold
+	Blocked bool `json:"blocked"`
+	// Result of the lookup on each configured domain blocklist domain
+	Listings []*BlocklistListing `json:"listings"`
+	// Results of the lookups of the domain that differed from the result before them, most recent first
+	History []*RecordChange `json:"history"`
+}
+
+// Contains an ip address or domain whose lookup still failed once its attempts were exhausted
+type DeadLetter struct {
+	// IPV4 or IPV6 address, in canonical form, or domain, in IDNA form, whose lookup failed
+	Target string `json:"target"`
+	// Kind of the target
+	Kind JobKind `json:"kind"`
+	// Identifier of the job whose lookup of the target last failed
+	JobID string `json:"job_id"`
+	// Number of times the target was looked up by that job
+	Attempts int `json:"attempts"`
+	// Outcome of the last lookup
+	LookupStatus LookupStatus `json:"lookup_status"`
+	// Timestamp indicating when the target was first added to the dead letters
+	CreatedAt time.Time `json:"created_at"`
+	// Timestamp indicating when the lookup of the target last failed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Contains the progress of the lookups requested by a single enqueue or enqueueDomains mutation
+type Job struct {
+	// Identifier of the job, to be passed to the job query
+	ID string `json:"id"`
+	// Kind of the targets of the job
+	Kind JobKind `json:"kind"`
+	// State of the job
+	State JobState `json:"state"`
+	// Priority the job was enqueued with
+	Priority JobPriority `json:"priority"`
+	// Number of ip addresses or domains in the job
+	Total int `json:"total"`
+	// Number of ip addresses or domains looked up and stored
+	Completed int `json:"completed"`
+	// Number of ip addresses or domains whose lookup failed
+	Failed int `json:"failed"`
+	// Number of ip addresses or domains not looked up, as the job was cancelled
+	Cancelled int `json:"cancelled"`
+	// Number of ip addresses or domains satisfied from a record stored within the configured freshness window, which are
+	// also counted as completed
+	Cached int `json:"cached"`
+	// Progress of each ip address or domain, in the order they were enqueued
+	Items []*JobItem `json:"items"`
+	// Timestamp indicating when the job was enqueued
+	CreatedAt time.Time `json:"created_at"`
+	// Timestamp indicating when the state of the job or one of its items last changed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Contains the progress of a single ip address or domain of a job
+type JobItem struct {
+	// IPV4 or IPV6 address, in canonical form, or domain, in IDNA form, to be looked up
+	Target string `json:"target"`
+	// State of the lookup
+	State JobItemState `json:"state"`
+	// Outcome of the lookup, or null while it is PENDING
+	LookupStatus *LookupStatus `json:"lookup_status"`
+	// Indicates if the lookup was satisfied from a record stored within the configured freshness window, instead of
+	// querying the blocklist domains again
+	Cached bool `json:"cached"`
+	// Timestamp indicating when the state of the lookup last changed
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// Contains the number of jobs of a single priority waiting on the job queue
+type QueueDepth struct {
+	// Priority of the jobs
+	Priority JobPriority `json:"priority"`
+	// Number of jobs waiting to be processed
+	Jobs int `json:"jobs"`
+}
+
+// Contains the state of the job queue
+type QueueStatus struct {
+	// Number of jobs waiting to be processed, for each priority from HIGH to LOW
+	Depth []*QueueDepth `json:"depth"`
+	// Number of jobs waiting to be processed, of all priorities
+	Jobs int `json:"jobs"`
+	// Number of jobs that can wait to be processed, the configured queue_length. An enqueue while the job queue is full
+	// fails, after waiting for room under the wait admission policy, with an error whose retryAfter extension hints how
+	// many seconds to wait before trying again
+	Capacity int `json:"capacity"`
+	// Indicates if the job queue is paused by the pauseQueue mutation
+	Paused bool `json:"paused"`
+}
+
+// Contains a result of looking up an ip address or domain, recorded when it differed from the result before it
+type RecordChange struct {
+	// Timestamp indicating when the lookup produced the result
+	ChangedAt time.Time `json:"changed_at"`
+	// Response code of the record, as in its response_code field
+	ResponseCode string `json:"response_code"`
+	// Indicates if the ip address or domain was listed on any of the blocklist domains
+	Listed bool `json:"listed"`
+	// Categories of the blocklist listings
+	Categories []string `json:"categories"`
+	// Severity of the most severe of the categories
+	Severity Severity `json:"severity"`
+	// Score of the blocklist listings
+	Score float64 `json:"score"`
+	// Indicates if the score reached the configured score threshold
+	Blocked bool `json:"blocked"`
+}
+
+// State of a single ip address or domain of a job
+type JobItemState string
+
+const (
+	// Not looked up yet
+	JobItemStatePending JobItemState = "PENDING"
+	// Looked up and stored
+	JobItemStateCompleted JobItemState = "COMPLETED"
+	// The lookup failed, in which case any previous result was kept
+	JobItemStateFailed JobItemState = "FAILED"
+	// Not looked up, as the job was cancelled
+	JobItemStateCancelled JobItemState = "CANCELLED"
+)
+
+var AllJobItemState = []JobItemState{
+	JobItemStatePending,
+	JobItemStateCompleted,
+	JobItemStateFailed,
+	JobItemStateCancelled,
+}
+
+func (e JobItemState) IsValid() bool {
+	switch e {
+	case JobItemStatePending, JobItemStateCompleted, JobItemStateFailed, JobItemStateCancelled:
+		return true
+	}
+	return false
+}
+
+func (e JobItemState) String() string {
+	return string(e)
+}
+
+func (e *JobItemState) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = JobItemState(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid JobItemState", str)
+	}
+	return nil
+}
+
+func (e JobItemState) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// Kind of the targets of a job
+type JobKind string
+
+const (
+	// Created by the enqueue mutation
+	JobKindIPAddress JobKind = "IP_ADDRESS"
+	// Created by the enqueueDomains mutation
+	JobKindDomain JobKind = "DOMAIN"
+)
+
+var AllJobKind = []JobKind{
+	JobKindIPAddress,
+	JobKindDomain,
+}
+
+func (e JobKind) IsValid() bool {
+	switch e {
+	case JobKindIPAddress, JobKindDomain:
+		return true
+	}
+	return false
+}
+
+func (e JobKind) String() string {
+	return string(e)
+}
+
+func (e *JobKind) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = JobKind(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid JobKind", str)
+	}
+	return nil
+}
+
+func (e JobKind) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// Priority of an enqueued job. Queued jobs are processed in priority order, and jobs of the same priority are taken
+// from each authenticated user in turn
+type JobPriority string
+
+const (
+	// Processed before any NORMAL or LOW job, such as an urgent check of a single ip address
+	JobPriorityHigh JobPriority = "HIGH"
+	// The default priority
+	JobPriorityNormal JobPriority = "NORMAL"
+	// Processed once no HIGH or NORMAL job is waiting, such as the scheduled refreshes of stale records
+	JobPriorityLow JobPriority = "LOW"
+)
+
+var AllJobPriority = []JobPriority{
+	JobPriorityHigh,
+	JobPriorityNormal,
+	JobPriorityLow,
+}
+
+func (e JobPriority) IsValid() bool {
+	switch e {
+	case JobPriorityHigh, JobPriorityNormal, JobPriorityLow:
+		return true
+	}
+	return false
+}
+
+func (e JobPriority) String() string {
+	return string(e)
+}
+
+func (e *JobPriority) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = JobPriority(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid JobPriority", str)
+	}
+	return nil
+}
+
+func (e JobPriority) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// State of an enqueued job
+type JobState string
+
+const (
+	// Waiting on the job queue
+	JobStateQueued JobState = "QUEUED"
+	// Being looked up
+	JobStateRunning JobState = "RUNNING"
+	// Every ip address or domain of the job was looked up
+	JobStateCompleted JobState = "COMPLETED"
+	// The job finished, but the lookup of at least one of its ip addresses or domains failed
+	JobStateFailed JobState = "FAILED"
+	// The job was cancelled by the cancelJob mutation, leaving the ip addresses or domains not looked up by then CANCELLED
+	JobStateCancelled JobState = "CANCELLED"
+)
+
+var AllJobState = []JobState{
+	JobStateQueued,
+	JobStateRunning,
+	JobStateCompleted,
+	JobStateFailed,
+	JobStateCancelled,
+}
+
+func (e JobState) IsValid() bool {
+	switch e {
+	case JobStateQueued, JobStateRunning, JobStateCompleted, JobStateFailed, JobStateCancelled:
+		return true
+	}
+	return false
+}
+
+func (e JobState) String() string {
+	return string(e)
+}
+
+func (e *JobState) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = JobState(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid JobState", str)
+	}
+	return nil
+}
+
+func (e JobState) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// Outcome of a blocklist lookup
+type LookupStatus string
+
+const (
+	// Listed on at least one of the blocklist domains
+	LookupStatusListed LookupStatus = "LISTED"
+	// Not listed on any of the blocklist domains, all of which answered
+	LookupStatusNotListed LookupStatus = "NOT_LISTED"
+	// Not listed on any of the blocklist domains that answered, and at least one blocklist domain failed to answer
+	LookupStatusError LookupStatus = "ERROR"
+	// As ERROR, where the first blocklist domain to fail did not answer in time
+	LookupStatusTimeout LookupStatus = "TIMEOUT"
+	// As ERROR, where the first blocklist domain to fail refused the query
+	LookupStatusRefused LookupStatus = "REFUSED"
+)
+
+var AllLookupStatus = []LookupStatus{
+	LookupStatusListed,
+	LookupStatusNotListed,
+	LookupStatusError,
+	LookupStatusTimeout,
+	LookupStatusRefused,
+}
+
+func (e LookupStatus) IsValid() bool {
+	switch e {
+	case LookupStatusListed, LookupStatusNotListed, LookupStatusError, LookupStatusTimeout, LookupStatusRefused:
+		return true
+	}
+	return false
+}
+
+func (e LookupStatus) String() string {
+	return string(e)
+}
+
+func (e *LookupStatus) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = LookupStatus(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid LookupStatus", str)
+	}
+	return nil
+}
+
+func (e LookupStatus) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// Severity of a blocklist listing
+type Severity string
+
+const (
+	SeverityNone     Severity = "NONE"
+	SeverityLow      Severity = "LOW"
+	SeverityMedium   Severity = "MEDIUM"
+	SeverityHigh     Severity = "HIGH"
+	SeverityCritical Severity = "CRITICAL"
+)
+
+var AllSeverity = []Severity{
+	SeverityNone,
+	SeverityLow,
+	SeverityMedium,
+	SeverityHigh,
+	SeverityCritical,
+}
+
+func (e Severity) IsValid() bool {
+	switch e {
+	case SeverityNone, SeverityLow, SeverityMedium, SeverityHigh, SeverityCritical:
+		return true
+	}
+	return false
+}
+
+func (e Severity) String() string {
+	return string(e)
+}
+
+func (e *Severity) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Severity(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Severity", str)
+	}
+	return nil
+}
+
+func (e Severity) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
+}
+
+// Combined verdict of the blocklist and allowlist listings of an ip address
+type Verdict string
+
+const (
+	// Listed on an allowlist and either not blocklisted, or allowed by the verdict policy
+	VerdictAllow Verdict = "ALLOW"
+	// Listed on a blocklist and either not allowlisted, or blocked by the verdict policy
+	VerdictBlock Verdict = "BLOCK"
+	// Not listed on any blocklist or allowlist, or tied under the score verdict policy
+	VerdictNeutral Verdict = "NEUTRAL"
+)
+
+var AllVerdict = []Verdict{
+	VerdictAllow,
+	VerdictBlock,
+	VerdictNeutral,
+}
+
+func (e Verdict) IsValid() bool {
+	switch e {
+	case VerdictAllow, VerdictBlock, VerdictNeutral:
+		return true
+	}
+	return false
+}
+
+func (e Verdict) String() string {
+	return string(e)
+}
+
+func (e *Verdict) UnmarshalGQL(v interface{}) error {
+	str, ok := v.(string)
+	if !ok {
+		return fmt.Errorf("enums must be strings")
+	}
+
+	*e = Verdict(str)
+	if !e.IsValid() {
+		return fmt.Errorf("%s is not a valid Verdict", str)
+	}
+	return nil
+}
+
+func (e Verdict) MarshalGQL(w io.Writer) {
+	fmt.Fprint(w, strconv.Quote(e.String()))
 }
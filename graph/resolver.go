@@ -1,16 +1,25 @@
 package graph
 
 import (
+	"errors"
+	"math"
+
+	"github.com/egreen64/codingchallenge/auth"
 	"github.com/egreen64/codingchallenge/config"
 	"github.com/egreen64/codingchallenge/db"
 	"github.com/egreen64/codingchallenge/dnsbl"
+	"github.com/egreen64/codingchallenge/graph/model"
 	"github.com/egreen64/codingchallenge/jobqueue"
+	"github.com/vektah/gqlparser/v2/gqlerror"
 )
 
 // This file will not be regenerated automatically.
 //
 // It serves as dependency injection for your app, add any dependencies you require here.
 
+//defaultMaxEnqueueAddresses is used when the maximum is not configured, allowing a /22
+const defaultMaxEnqueueAddresses = 1024
+
 //Resolver Type
 type Resolver struct {
 	Config   *config.File
@@ -18,3 +27,116 @@ type Resolver struct {
 	DNSBL    *dnsbl.Dnsbl
 	JobQueue *jobqueue.JobQueue
 }
+
+//user returns the user with the username, the user of the auth section or one of
+//its users, or nil if there is none
+func (r *Resolver) user(username string) *config.User {
+	if username == r.Config.Auth.Username {
+		return &config.User{Username: r.Config.Auth.Username, Password: r.Config.Auth.Password, Operator: true}
+	}
+	for i := range r.Config.Auth.Users {
+		if r.Config.Auth.Users[i].Username == username {
+			return &r.Config.Auth.Users[i]
+		}
+	}
+	return nil
+}
+
+//validateJWT validates the token against the credentials of the user it was issued to
+func (r *Resolver) validateJWT(tokenString string) (bool, error) {
+	user := r.user(auth.Owner(tokenString))
+	if user == nil {
+		return false, errors.New("invalid token")
+	}
+	return auth.ValidateJWT(tokenString, user.Username, user.Password)
+}
+
+//operator reports whether the token was issued to an operator, who may pause and
+//resume the job queue and cancel the jobs of any user
+func (r *Resolver) operator(tokenString string) bool {
+	user := r.user(auth.Owner(tokenString))
+	return user != nil && user.Operator
+}
+
+//queueError converts an error adding a job to the job queue into a GraphQL error. A
+//full queue is reported with a QUEUE_FULL code and the seconds to wait before trying
+//again in retryAfter.
+func queueError(err error) error {
+	var fullErr *jobqueue.QueueFullError
+	if errors.As(err, &fullErr) {
+		gqlErr := gqlerror.Errorf("unable to queue job - job queue is curently full. please try again")
+		gqlErr.Extensions = map[string]interface{}{
+			"code":       "QUEUE_FULL",
+			"retryAfter": int(math.Ceil(fullErr.RetryAfter.Seconds())),
+		}
+		return gqlErr
+	}
+	if errors.Is(err, jobqueue.ErrQueueFull) {
+		return gqlerror.Errorf("unable to queue job - job queue is curently full. please try again")
+	}
+	return gqlerror.Errorf("unable to queue job: %s", err)
+}
+
+//lookupNowError converts an error looking up the ip address with the lookupNow query
+//into a GraphQL error. A lookup refused by the rate limit is reported with a
+//RATE_LIMITED code and the seconds to wait before trying again in retryAfter.
+func (r *Resolver) lookupNowError(ip string, err error) error {
+	var rateErr *jobqueue.RateLimitError
+	var failedErr *jobqueue.LookupFailedError
+	switch {
+	case errors.As(err, &rateErr):
+		gqlErr := gqlerror.Errorf("unable to look up %s - too many lookups requested. please try again", ip)
+		gqlErr.Extensions = map[string]interface{}{
+			"code":       "RATE_LIMITED",
+			"retryAfter": int(math.Ceil(rateErr.RetryAfter.Seconds())),
+		}
+		return gqlErr
+	case errors.As(err, &failedErr):
+		gqlErr := gqlerror.Errorf("unable to look up %s - lookup failed with status %s", ip, failedErr.LookupStatus)
+		gqlErr.Extensions = map[string]interface{}{
+			"code":         "LOOKUP_FAILED",
+			"lookupStatus": failedErr.LookupStatus,
+		}
+		return gqlErr
+	case errors.Is(err, jobqueue.ErrLookupTimeout):
+		return gqlerror.Errorf("unable to look up %s - lookup did not finish within %s", ip, r.JobQueue.LookupNowTimeout)
+	default:
+		return gqlerror.Errorf("unable to look up %s: %s", ip, err)
+	}
+}
+
+//jobPriority returns the priority requested for an enqueued job, NORMAL if none was
+func jobPriority(priority *model.JobPriority) model.JobPriority {
+	if priority == nil {
+		return model.JobPriorityNormal
+	}
+	return *priority
+}
+
+//maxEnqueueAddresses returns the largest number of ip addresses a single enqueue may expand to
+func (r *Resolver) maxEnqueueAddresses() int {
+	if r.Config.JobQueue.MaxEnqueueAddresses <= 0 {
+		return defaultMaxEnqueueAddresses
+	}
+	return r.Config.JobQueue.MaxEnqueueAddresses
+}
+
+//queueStatus reports the depth and capacity of the job queue and whether it is paused
+func (r *Resolver) queueStatus() (*model.QueueStatus, error) {
+	depth, err := r.JobQueue.QueueDepth()
+	if err != nil {
+		return nil, err
+	}
+
+	jobs := 0
+	for _, d := range depth {
+		jobs += d.Jobs
+	}
+
+	return &model.QueueStatus{
+		Depth:    depth,
+		Jobs:     jobs,
+		Capacity: r.JobQueue.QueueLength,
+		Paused:   r.JobQueue.Paused(),
+	}, nil
+}
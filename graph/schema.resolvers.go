@@ -17,7 +17,8 @@ import (
 )
 
 func (r *mutationResolver) Authenticate(ctx context.Context, username string, password string) (*model.AuthToken, error) {
-	if username != r.Config.Auth.Username || password != r.Config.Auth.Password {
+	user := r.user(username)
+	if user == nil || password != user.Password {
 		return nil, gqlerror.Errorf("invalid credentials")
 	}
 
@@ -27,38 +28,183 @@ func (r *mutationResolver) Authenticate(ctx context.Context, username string, pa
 	return &model.AuthToken{BearerToken: authToken}, err
 }
 
-func (r *mutationResolver) Enqueue(ctx context.Context, ip []string) (*bool, error) {
+func (r *mutationResolver) Enqueue(ctx context.Context, ip []string, priority *model.JobPriority) (*model.Job, error) {
 	authToken := auth.GetContextToken(ctx)
 	if authToken == "" {
 		return nil, gqlerror.Errorf("missing auth token")
 	}
 
 	jwt := strings.TrimPrefix(authToken, "Bearer ")
-	valid, err := auth.ValidateJWT(jwt, r.Config.Auth.Username, r.Config.Auth.Password)
+	valid, err := r.validateJWT(jwt)
 
 	if !valid || err != nil {
 		return nil, gqlerror.Errorf("not authorized")
 	}
 
-	//Validate ip addresses
+	//Validate ip addresses, CIDR blocks and ranges
 	invalidIPAddresses := false
+	ipRanges := make([]utils.IPRange, 0, len(ip))
 	for _, ipAddr := range ip {
-		if !utils.IsValidIPV4Address(ipAddr) {
+		ipRange, err := utils.ParseIPRange(ipAddr)
+		if err != nil {
 			invalidIPAddresses = true
-			graphql.AddError(ctx, gqlerror.Errorf("invalid IPV4 address: %s", ipAddr))
+			graphql.AddError(ctx, gqlerror.Errorf("%s", err))
 		}
+		ipRanges = append(ipRanges, ipRange)
 	}
 	if invalidIPAddresses {
 		return nil, gqlerror.Errorf("validation error(s)")
 	}
 
-	if !r.JobQueue.AddJob(ip) {
-		return nil, gqlerror.Errorf("unable to queue job - job queue is curently full. please try again")
+	ipAddrs, err := utils.ExpandIPRanges(ipRanges, r.maxEnqueueAddresses())
+	if err != nil {
+		return nil, gqlerror.Errorf("%s", err)
+	}
+
+	jobID, err := r.JobQueue.AddJob(ctx, ipAddrs, jobPriority(priority), auth.Owner(jwt))
+	if err != nil {
+		return nil, queueError(err)
+	}
+
+	return r.Database.SelectJob(jobID)
+}
+
+func (r *mutationResolver) EnqueueDomains(ctx context.Context, domain []string, priority *model.JobPriority) (*model.Job, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	jwt := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(jwt)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	//Validate domains
+	invalidDomains := false
+	for _, d := range domain {
+		if !utils.IsValidDomain(d) {
+			invalidDomains = true
+			graphql.AddError(ctx, gqlerror.Errorf("invalid domain: %s", d))
+		}
+	}
+	if invalidDomains {
+		return nil, gqlerror.Errorf("validation error(s)")
+	}
+
+	jobID, err := r.JobQueue.AddDomainJob(ctx, domain, jobPriority(priority), auth.Owner(jwt))
+	if err != nil {
+		return nil, queueError(err)
+	}
+
+	return r.Database.SelectJob(jobID)
+}
+
+func (r *mutationResolver) RetryDeadLetters(ctx context.Context, target []string) ([]*model.Job, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
 	}
 
-	result := true
+	jwt := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(jwt)
 
-	return &result, nil
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	jobIDs, err := r.JobQueue.RetryDeadLetters(ctx, target, auth.Owner(jwt))
+	if err != nil {
+		return nil, queueError(err)
+	}
+
+	jobs := make([]*model.Job, 0, len(jobIDs))
+	for _, jobID := range jobIDs {
+		job, err := r.Database.SelectJob(jobID)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+func (r *mutationResolver) CancelJob(ctx context.Context, id string) (*model.Job, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	jwt := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(jwt)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	owner, err := r.Database.SelectJobOwner(id)
+	if err != nil {
+		return nil, gqlerror.Errorf("%s", err)
+	}
+
+	//Users may only cancel their own jobs, and operators those of any user
+	if owner != auth.Owner(jwt) && !r.operator(jwt) {
+		return nil, gqlerror.Errorf("not authorized to cancel job %s", id)
+	}
+
+	_, err = r.JobQueue.CancelJob(id)
+	if err != nil {
+		return nil, gqlerror.Errorf("unable to cancel job: %s", err)
+	}
+
+	return r.Database.SelectJob(id)
+}
+
+func (r *mutationResolver) PauseQueue(ctx context.Context) (*model.QueueStatus, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	jwt := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(jwt)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	if !r.operator(jwt) {
+		return nil, gqlerror.Errorf("not authorized - operator access required")
+	}
+
+	r.JobQueue.Pause()
+
+	return r.queueStatus()
+}
+
+func (r *mutationResolver) ResumeQueue(ctx context.Context) (*model.QueueStatus, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	jwt := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(jwt)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	if !r.operator(jwt) {
+		return nil, gqlerror.Errorf("not authorized - operator access required")
+	}
+
+	r.JobQueue.Resume()
+
+	return r.queueStatus()
 }
 
 func (r *queryResolver) GetIPDetails(ctx context.Context, ip *string) (*model.DNSBlockListRecord, error) {
@@ -68,30 +214,150 @@ func (r *queryResolver) GetIPDetails(ctx context.Context, ip *string) (*model.DN
 	}
 
 	tokenString := strings.TrimPrefix(authToken, "Bearer ")
-	valid, err := auth.ValidateJWT(tokenString, r.Config.Auth.Username, r.Config.Auth.Password)
+	valid, err := r.validateJWT(tokenString)
 
 	if !valid || err != nil {
 		return nil, gqlerror.Errorf("not authorized")
 	}
 
-	if !utils.IsValidIPV4Address(*ip) {
-		return nil, gqlerror.Errorf("invalid IPV4 address: %s", *ip)
+	if !utils.IsValidIPAddress(*ip) {
+		return nil, gqlerror.Errorf("invalid IP address: %s", *ip)
 	}
 
 	dblRec, err := r.Database.SelectRecord(*ip)
 	if err != nil {
 		dblRec = &model.DNSBlockListRecord{
-			IPAddress:    *ip,
+			IPAddress:    utils.CanonicalIPAddress(*ip),
+			ResponseCode: "NXDOMAIN",
+			UUID:         "",
+			CreatedAt:    time.Now(),
+			UpdatedAt:    time.Now(),
+			Categories:   []string{},
+			Severity:     model.SeverityNone,
+			Verdict:      model.VerdictNeutral,
+			LookupStatus: model.LookupStatusNotListed,
+			Listings:     []*model.BlocklistListing{},
+			History:      []*model.RecordChange{},
+		}
+	}
+
+	return dblRec, nil
+}
+
+func (r *queryResolver) LookupNow(ctx context.Context, ip string) (*model.DNSBlockListRecord, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	tokenString := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(tokenString)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	if !utils.IsValidIPAddress(ip) {
+		return nil, gqlerror.Errorf("invalid IP address: %s", ip)
+	}
+
+	dblRec, err := r.JobQueue.LookupNow(ctx, ip)
+	if err != nil {
+		return nil, r.lookupNowError(ip, err)
+	}
+
+	return dblRec, nil
+}
+
+func (r *queryResolver) GetDomainDetails(ctx context.Context, domain string) (*model.DNSDomainBlockListRecord, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	tokenString := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(tokenString)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	if !utils.IsValidDomain(domain) {
+		return nil, gqlerror.Errorf("invalid domain: %s", domain)
+	}
+
+	dblRec, err := r.Database.SelectDomainRecord(domain)
+	if err != nil {
+		dblRec = &model.DNSDomainBlockListRecord{
+			Domain:       utils.NormalizeDomain(domain),
 			ResponseCode: "NXDOMAIN",
 			UUID:         "",
 			CreatedAt:    time.Now(),
 			UpdatedAt:    time.Now(),
+			Categories:   []string{},
+			Severity:     model.SeverityNone,
+			LookupStatus: model.LookupStatusNotListed,
+			Listings:     []*model.BlocklistListing{},
+			History:      []*model.RecordChange{},
 		}
 	}
 
 	return dblRec, nil
 }
 
+func (r *queryResolver) Job(ctx context.Context, id string) (*model.Job, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	tokenString := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(tokenString)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	job, err := r.Database.SelectJob(id)
+	if err != nil {
+		return nil, nil
+	}
+
+	return job, nil
+}
+
+func (r *queryResolver) DeadLetters(ctx context.Context) ([]*model.DeadLetter, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	tokenString := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(tokenString)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	return r.Database.SelectDeadLetters()
+}
+
+func (r *queryResolver) QueueStatus(ctx context.Context) (*model.QueueStatus, error) {
+	authToken := auth.GetContextToken(ctx)
+	if authToken == "" {
+		return nil, gqlerror.Errorf("missing auth token")
+	}
+
+	tokenString := strings.TrimPrefix(authToken, "Bearer ")
+	valid, err := r.validateJWT(tokenString)
+
+	if !valid || err != nil {
+		return nil, gqlerror.Errorf("not authorized")
+	}
+
+	return r.queueStatus()
+}
+
 // Mutation returns generated.MutationResolver implementation.
 func (r *Resolver) Mutation() generated.MutationResolver { return &mutationResolver{r} }
 
@@ -0,0 +1,72 @@
+package jobqueue
+
+import (
+	"context"
+	"time"
+)
+
+const (
+	defaultAdmissionTimeout = 5 * time.Second
+	minRetryAfter           = time.Second
+)
+
+//QueueFullError type - returned when a job cannot be queued because the job queue is
+//full, RetryAfter hinting how long to wait before adding the job again. It matches
+//ErrQueueFull with errors.Is.
+type QueueFullError struct {
+	RetryAfter time.Duration
+}
+
+func (e *QueueFullError) Error() string {
+	return ErrQueueFull.Error()
+}
+
+//Unwrap function
+func (e *QueueFullError) Unwrap() error {
+	return ErrQueueFull
+}
+
+//push queues the job. Under AdmissionWait a full queue is waited on until it makes
+//room for the job, for at most AdmissionTimeout and no longer than ctx allows.
+func (jq *JobQueue) push(ctx context.Context, j job) error {
+	err := jq.queue.push(j)
+	if err == ErrQueueFull && jq.Admission == AdmissionWait {
+		ctx, cancel := context.WithTimeout(ctx, jq.AdmissionTimeout)
+		defer cancel()
+		for err == ErrQueueFull && jq.queue.wait(ctx) {
+			err = jq.queue.push(j)
+		}
+	}
+	if err == ErrQueueFull {
+		return &QueueFullError{RetryAfter: jq.retryAfter()}
+	}
+
+	return err
+}
+
+//observeJob folds the time taken to process a job into the moving average of the
+//job durations
+func (jq *JobQueue) observeJob(duration time.Duration) {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	if jq.jobDuration == 0 {
+		jq.jobDuration = duration
+		return
+	}
+	jq.jobDuration += (duration - jq.jobDuration) / 8
+}
+
+//retryAfter estimates when the queue next makes room for a job: once the first of
+//the busy workers finishes its job, on average after the average job duration
+//divided among the workers, but no sooner than minRetryAfter
+func (jq *JobQueue) retryAfter() time.Duration {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	retryAfter := jq.jobDuration / time.Duration(jq.Workers)
+	if retryAfter < minRetryAfter {
+		return minRetryAfter
+	}
+	return retryAfter.Round(time.Second)
+}
@@ -0,0 +1,81 @@
+package jobqueue
+
+import (
+	"time"
+
+	"github.com/egreen64/codingchallenge/dnsbl"
+	"github.com/egreen64/codingchallenge/graph/model"
+)
+
+//lookupResult type - the outcome of looking up and storing an ip address or domain.
+//pending is set if the lookup was abandoned before it finished.
+type lookupResult struct {
+	lookupStatus model.LookupStatus
+	failed       bool
+	pending      bool
+}
+
+//flight type - a lookup in flight, whose result is shared by every job looking up
+//the same ip address or domain before it finishes
+type flight struct {
+	done   chan struct{}
+	result lookupResult
+}
+
+//coalesce calls lookup unless a lookup of the target is already in flight, in which
+//case it waits for that lookup and returns its result instead
+func (jq *JobQueue) coalesce(kind model.JobKind, target string, lookup func() lookupResult) lookupResult {
+	key := string(kind) + "/" + target
+
+	jq.mu.Lock()
+	if f, ok := jq.flights[key]; ok {
+		jq.mu.Unlock()
+		<-f.done
+		return f.result
+	}
+	f := &flight{done: make(chan struct{})}
+	jq.flights[key] = f
+	jq.mu.Unlock()
+
+	f.result = lookup()
+
+	jq.mu.Lock()
+	delete(jq.flights, key)
+	jq.mu.Unlock()
+	close(f.done)
+
+	return f.result
+}
+
+//fresh returns the lookup status of the stored record of the target if its result
+//was stored within the Freshness window. The records refreshed by the Scheduler are
+//always looked up again.
+func (jq *JobQueue) fresh(j job, target string) (model.LookupStatus, bool) {
+	if jq.Freshness <= 0 || j.owner == refreshOwner {
+		return "", false
+	}
+
+	var lookupStatus model.LookupStatus
+	var updatedAt time.Time
+	switch j.kind {
+	case model.JobKindDomain:
+		record, err := jq.db.SelectDomainRecord(target)
+		if err != nil {
+			return "", false
+		}
+		lookupStatus, updatedAt = record.LookupStatus, record.UpdatedAt
+	default:
+		record, err := jq.db.SelectRecord(target)
+		if err != nil {
+			return "", false
+		}
+		lookupStatus, updatedAt = record.LookupStatus, record.UpdatedAt
+	}
+
+	//A record whose lookups have all failed has no result to reuse
+	if dnsbl.LookupStatus(lookupStatus).Failed() || time.Since(updatedAt) > jq.Freshness {
+		return "", false
+	}
+
+	return lookupStatus, true
+}
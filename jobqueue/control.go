@@ -0,0 +1,113 @@
+package jobqueue
+
+import (
+	"log"
+)
+
+//CancelJob function - cancels the job if it is queued or running. A queued job is
+//taken off the queue, while the worker of a running job finishes the lookups in
+//flight and leaves the remaining targets cancelled. Returns false if the job was
+//not queued or running.
+func (jq *JobQueue) CancelJob(id string) (bool, error) {
+	cancelled, err := jq.db.CancelJob(id)
+	if err != nil || !cancelled {
+		return false, err
+	}
+
+	jq.mu.Lock()
+	jq.cancelled[id] = true
+	jq.mu.Unlock()
+
+	//A job taken off the queue is never seen by a worker
+	if jq.queue.remove(id) {
+		jq.forgetCancelled(id)
+	}
+
+	log.Printf("job queue cancelled job %s\n", id)
+	return true, nil
+}
+
+//isCancelled reports whether the job has been cancelled while on the queue or running
+func (jq *JobQueue) isCancelled(id string) bool {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+	return jq.cancelled[id]
+}
+
+func (jq *JobQueue) forgetCancelled(id string) {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+	delete(jq.cancelled, id)
+}
+
+//Pause function - stops the workers from taking jobs off the queue and starting
+//lookups, including retries, until the queue is resumed. Jobs are still queued
+//until the queue is full, and may be cancelled while they wait.
+func (jq *JobQueue) Pause() {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	select {
+	case <-jq.resumed:
+		jq.resumed = make(chan struct{})
+		close(jq.paused)
+		log.Println("job queue paused")
+	default:
+	}
+}
+
+//Resume function - resumes the queue paused by Pause
+func (jq *JobQueue) Resume() {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+
+	select {
+	case <-jq.resumed:
+	default:
+		close(jq.resumed)
+		jq.paused = make(chan struct{})
+		log.Println("job queue resumed")
+	}
+}
+
+//Paused function - reports whether the queue is paused
+func (jq *JobQueue) Paused() bool {
+	jq.mu.Lock()
+	resumed := jq.resumed
+	jq.mu.Unlock()
+
+	select {
+	case <-resumed:
+		return false
+	default:
+		return true
+	}
+}
+
+//pausing returns the channel closed once the queue is paused
+func (jq *JobQueue) pausing() <-chan struct{} {
+	jq.mu.Lock()
+	defer jq.mu.Unlock()
+	return jq.paused
+}
+
+//waitResumed waits while the queue is paused, returning false if the queue is
+//stopped before it is resumed
+func (jq *JobQueue) waitResumed() bool {
+	jq.mu.Lock()
+	resumed := jq.resumed
+	jq.mu.Unlock()
+
+	select {
+	case <-resumed:
+		return true
+	default:
+	}
+
+	select {
+	case <-resumed:
+		return true
+	case <-jq.stopChannel:
+		return false
+	}
+}
@@ -0,0 +1,198 @@
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/egreen64/codingchallenge/db"
+	"github.com/egreen64/codingchallenge/graph/model"
+	"github.com/google/uuid"
+)
+
+const (
+	defaultLeaseDuration = 60 * time.Second
+	defaultPollInterval  = 500 * time.Millisecond
+)
+
+//dbQueue type - queues the jobs in the database. A worker leases the job it takes,
+//renewing the lease while the job is processed and removing the job once it has
+//been. Should the service stop before then, the lease expires, or is released when
+//the service starts again, and the job is resumed with the targets still pending.
+//A target may therefore be looked up more than once, but never left out.
+//Only a single instance of the service may use the database, as the leases it finds
+//when starting are taken to be those of its previous run.
+type dbQueue struct {
+	db            *db.Database
+	capacity      int
+	owner         string
+	leaseDuration time.Duration
+	pollInterval  time.Duration
+	mu            sync.Mutex
+	leases        map[string]chan struct{}
+}
+
+func newDBQueue(database *db.Database, capacity int) *dbQueue {
+	return &dbQueue{
+		db:            database,
+		capacity:      capacity,
+		owner:         uuid.New().String(),
+		leaseDuration: defaultLeaseDuration,
+		pollInterval:  defaultPollInterval,
+		leases:        map[string]chan struct{}{},
+	}
+}
+
+func (q *dbQueue) push(j job) error {
+	queued, err := q.db.QueueJob(j.id, j.priority, j.owner, q.capacity)
+	if err != nil {
+		return err
+	}
+	if !queued {
+		return ErrQueueFull
+	}
+
+	return nil
+}
+
+//pop polls the database every pollInterval until a job can be leased. No job is
+//leased once stop is closed, as the jobs released by the stopping workers would
+//otherwise be taken again, nor once paused is closed.
+func (q *dbQueue) pop(stop, paused <-chan struct{}) (job, bool) {
+	for {
+		select {
+		case <-stop:
+			return job{}, false
+		case <-paused:
+			return job{}, false
+		default:
+		}
+
+		j, ok := q.tryPop()
+		if ok {
+			return j, true
+		}
+
+		select {
+		case <-stop:
+			return job{}, false
+		case <-paused:
+			return job{}, false
+		case <-time.After(q.pollInterval):
+		}
+	}
+}
+
+func (q *dbQueue) tryPop() (job, bool) {
+	for {
+		id, err := q.db.LeaseJob(q.owner, q.leaseDuration)
+		if err != nil || id == "" {
+			return job{}, false
+		}
+
+		record, err := q.db.SelectJob(id)
+		var notFound *db.JobNotFoundError
+		if errors.As(err, &notFound) || err == nil && record.State == model.JobStateCancelled {
+			//The job no longer exists, or was cancelled, leaving nothing to process
+			q.db.AckJob(id)
+			continue
+		}
+
+		var owner string
+		if err == nil {
+			owner, err = q.db.SelectJobOwner(id)
+		}
+		if err != nil {
+			//The job is left on the queue for the next poll
+			q.db.ReleaseJob(id, q.owner)
+			return job{}, false
+		}
+
+		j := job{id: id, kind: record.Kind, priority: record.Priority, owner: owner, targets: make([]string, len(record.Items))}
+		for i, item := range record.Items {
+			j.targets[i] = item.Target
+			switch item.State {
+			case model.JobItemStatePending:
+				j.positions = append(j.positions, i)
+			case model.JobItemStateFailed:
+				j.failed = true
+			}
+		}
+		if j.positions == nil {
+			j.positions = []int{}
+		}
+
+		q.renew(id)
+
+		return j, true
+	}
+}
+
+func (q *dbQueue) depth() (map[model.JobPriority]int, error) {
+	return q.db.QueueDepth()
+}
+
+func (q *dbQueue) ack(j job) {
+	q.stopRenewing(j.id)
+	q.db.AckJob(j.id)
+}
+
+func (q *dbQueue) release(j job) {
+	q.stopRenewing(j.id)
+	q.db.UpdateJobState(j.id, model.JobStateQueued)
+	q.db.ReleaseJob(j.id, q.owner)
+}
+
+//wait polls the database every pollInterval, as the queue makes room for a job once
+//any of the workers takes a job
+func (q *dbQueue) wait(ctx context.Context) bool {
+	select {
+	case <-time.After(q.pollInterval):
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (q *dbQueue) remove(id string) bool {
+	removed, _ := q.db.UnqueueJob(id)
+	return removed
+}
+
+//renew keeps renewing the lease on the job, a third of the lease duration before
+//it expires, until the job is acknowledged or released
+func (q *dbQueue) renew(id string) {
+	done := make(chan struct{})
+
+	q.mu.Lock()
+	q.leases[id] = done
+	q.mu.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(q.leaseDuration / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				renewed, err := q.db.RenewJobLease(id, q.owner, q.leaseDuration)
+				if err == nil && !renewed {
+					log.Printf("job queue lost the lease on job %s\n", id)
+					return
+				}
+			}
+		}
+	}()
+}
+
+func (q *dbQueue) stopRenewing(id string) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if done, ok := q.leases[id]; ok {
+		close(done)
+		delete(q.leases, id)
+	}
+}
@@ -1,6 +1,8 @@
 package jobqueue
 
 import (
+	"context"
+	"errors"
 	"log"
 	"sync"
 	"time"
@@ -9,39 +11,184 @@ import (
 	"github.com/egreen64/codingchallenge/db"
 	"github.com/egreen64/codingchallenge/dnsbl"
 	"github.com/egreen64/codingchallenge/graph/model"
+	"github.com/egreen64/codingchallenge/utils"
 	"github.com/google/uuid"
 )
 
+//ErrQueueFull is returned when a job cannot be queued because the job queue is full
+var ErrQueueFull = errors.New("job queue is full")
+
+//job type - the ip addresses or domains to be looked up by a single enqueue request
+type job struct {
+	id       string
+	kind     model.JobKind
+	priority model.JobPriority
+	owner    string
+	targets  []string
+
+	//positions are those of the targets still to be looked up when the job is
+	//resumed, and failed is set if a lookup of the job had already failed
+	positions []int
+	failed    bool
+}
+
+//pending returns the positions of the targets to be looked up
+func (j job) pending() []int {
+	if j.positions != nil {
+		return j.positions
+	}
+	positions := make([]int, len(j.targets))
+	for i := range positions {
+		positions[i] = i
+	}
+	return positions
+}
+
+const (
+	defaultWorkers            = 4
+	defaultJobParallelism     = 4
+	defaultMaxInFlightLookups = 16
+	defaultStopTimeout        = 5 * time.Second
+)
+
 //JobQueue type
 type JobQueue struct {
-	dnsbl       *dnsbl.Dnsbl
-	db          *db.Database
-	jobChannel  chan []string
-	stopChannel chan struct{}
-	wg          sync.WaitGroup
+	QueueLength        int
+	Workers            int
+	JobParallelism     int
+	MaxInFlightLookups int
+	StopPolicy         StopPolicy
+	StopTimeout        time.Duration
+	MaxAttempts        int
+	RetryBackoff       time.Duration
+	RetryMaxBackoff    time.Duration
+	Freshness          time.Duration
+	Admission          AdmissionPolicy
+	AdmissionTimeout   time.Duration
+	LookupNowTimeout   time.Duration
+	dnsbl              *dnsbl.Dnsbl
+	db                 *db.Database
+	queue              queue
+	stopChannel        chan struct{}
+	lookups            chan struct{}
+	wg                 sync.WaitGroup
+	mu                 sync.Mutex
+	cancelled          map[string]bool
+	resumed            chan struct{}
+	paused             chan struct{}
+	flights            map[string]*flight
+	jobDuration        time.Duration
+	lookupNowLimiter   *limiter
 }
 
 //NewJobQueue function
 func NewJobQueue(config *config.File, dnsbl *dnsbl.Dnsbl, db *db.Database) *JobQueue {
 	jobQueue := JobQueue{
-		dnsbl:       dnsbl,
-		db:          db,
-		jobChannel:  make(chan []string, config.JobQueue.QueueLength),
-		stopChannel: make(chan struct{}),
-		wg:          sync.WaitGroup{},
+		QueueLength:        config.JobQueue.QueueLength,
+		Workers:            config.JobQueue.Workers,
+		JobParallelism:     config.JobQueue.JobParallelism,
+		MaxInFlightLookups: config.JobQueue.MaxInFlightLookups,
+		StopTimeout:        time.Duration(config.JobQueue.StopTimeoutSeconds) * time.Second,
+		MaxAttempts:        config.JobQueue.MaxAttempts,
+		RetryBackoff:       time.Duration(config.JobQueue.RetryBackoffMs) * time.Millisecond,
+		RetryMaxBackoff:    time.Duration(config.JobQueue.RetryMaxBackoffMs) * time.Millisecond,
+		Freshness:          time.Duration(config.JobQueue.FreshnessSeconds) * time.Second,
+		AdmissionTimeout:   time.Duration(config.JobQueue.AdmissionTimeoutMs) * time.Millisecond,
+		LookupNowTimeout:   time.Duration(config.LookupNow.TimeoutMs) * time.Millisecond,
+		dnsbl:              dnsbl,
+		db:                 db,
+		stopChannel:        make(chan struct{}),
+		wg:                 sync.WaitGroup{},
+		cancelled:          map[string]bool{},
+		resumed:            make(chan struct{}),
+		paused:             make(chan struct{}),
+		flights:            map[string]*flight{},
+	}
+	close(jobQueue.resumed)
+	if jobQueue.Workers <= 0 {
+		jobQueue.Workers = defaultWorkers
+	}
+	if jobQueue.JobParallelism <= 0 {
+		jobQueue.JobParallelism = defaultJobParallelism
+	}
+	if jobQueue.MaxInFlightLookups <= 0 {
+		jobQueue.MaxInFlightLookups = defaultMaxInFlightLookups
+	}
+	if jobQueue.StopTimeout <= 0 {
+		jobQueue.StopTimeout = defaultStopTimeout
+	}
+	if jobQueue.MaxAttempts <= 0 {
+		jobQueue.MaxAttempts = defaultMaxAttempts
+	}
+	if jobQueue.RetryBackoff <= 0 {
+		jobQueue.RetryBackoff = defaultRetryBackoff
+	}
+	if jobQueue.RetryMaxBackoff <= 0 {
+		jobQueue.RetryMaxBackoff = defaultRetryMaxBackoff
+	}
+	if jobQueue.AdmissionTimeout <= 0 {
+		jobQueue.AdmissionTimeout = defaultAdmissionTimeout
+	}
+	if jobQueue.LookupNowTimeout <= 0 {
+		jobQueue.LookupNowTimeout = defaultLookupNowTimeout
+	}
+
+	requestsPerMinute, burst := config.LookupNow.RequestsPerMinute, config.LookupNow.Burst
+	if requestsPerMinute <= 0 {
+		requestsPerMinute = defaultLookupNowRequestsPerMinute
+	}
+	if burst <= 0 {
+		burst = defaultLookupNowBurst
+	}
+	jobQueue.lookupNowLimiter = newLimiter(requestsPerMinute, burst)
+
+	stopPolicy, err := ParseStopPolicy(config.JobQueue.StopPolicy)
+	if err != nil {
+		log.Fatalf("invalid job queue stop policy configuration: %s\n", err)
+	}
+	jobQueue.StopPolicy = stopPolicy
+
+	admission, err := ParseAdmissionPolicy(config.JobQueue.Admission)
+	if err != nil {
+		log.Fatalf("invalid job queue admission configuration: %s\n", err)
+	}
+	jobQueue.Admission = admission
+
+	jobQueue.queue, err = newQueue(config.JobQueue.Store, config.JobQueue.QueueLength, db)
+	if err != nil {
+		log.Fatalf("invalid job queue store configuration: %s\n", err)
+	}
+	if q, ok := jobQueue.queue.(*dbQueue); ok {
+		if config.JobQueue.LeaseSeconds > 0 {
+			q.leaseDuration = time.Duration(config.JobQueue.LeaseSeconds) * time.Second
+		}
+		if config.JobQueue.PollIntervalMs > 0 {
+			q.pollInterval = time.Duration(config.JobQueue.PollIntervalMs) * time.Millisecond
+		}
+
+		//Leases held when the service last stopped are released, resuming their jobs
+		count, err := db.ReleaseJobLeases()
+		if err == nil && count > 0 {
+			log.Printf("job queue resuming %d unfinished jobs\n", count)
+		}
 	}
 
-	jobQueue.wg.Add(1)
+	jobQueue.lookups = make(chan struct{}, jobQueue.MaxInFlightLookups)
 
-	go jobQueue.worker()
-	log.Println("job queue started")
+	jobQueue.wg.Add(jobQueue.Workers)
+	for i := 0; i < jobQueue.Workers; i++ {
+		go jobQueue.worker()
+	}
+	log.Printf("job queue started with %d workers\n", jobQueue.Workers)
 
 	return &jobQueue
 }
 
-//Stop function
+//Stop function - stops the workers according to the StopPolicy, waiting at most
+//StopTimeout for them. Jobs waiting in the database are resumed after a restart.
+//Returns false if the workers did not stop in time.
 func (jq *JobQueue) Stop() bool {
-	log.Println("stopping job queue")
+	log.Printf("stopping job queue, policy: %s\n", jq.StopPolicy)
 	close(jq.stopChannel)
 	ch := make(chan struct{})
 	go func() {
@@ -53,55 +200,419 @@ func (jq *JobQueue) Stop() bool {
 	case <-ch:
 		log.Println("job queue stopped")
 		return true
-	case <-time.After(5 * time.Second):
+	case <-time.After(jq.StopTimeout):
 		log.Println("timed out waiting for job queue to stop")
 		return false
 	}
 }
 
-//AddJob function
-func (jq *JobQueue) AddJob(ipAddresses []string) bool {
-	select {
-	case jq.jobChannel <- ipAddresses:
-		log.Printf("queued job for ip addresses: %+v\n", ipAddresses)
-		return true
-	default:
-		log.Printf("queue busy - unable to queue job for ip addresses: %+v\n", ipAddresses)
-		return false
+//AddJob function - queues the ip addresses to be looked up with the priority on
+//behalf of owner, the authenticated user, returning the id of the job. Up to
+//QueueLength jobs wait on the queue, a job added while it is full being rejected, or
+//under AdmissionWait waiting up to AdmissionTimeout for room until ctx is done. The
+//Workers look up up to JobParallelism targets of their jobs at once, with no more
+//than MaxInFlightLookups lookups in flight across all workers. Lookups of the same
+//target in flight at once are coalesced, and a record stored within Freshness is
+//reused. A lookup failing with an ERROR or TIMEOUT is retried with backoff up to
+//MaxAttempts times, after which the target is added to the dead letters.
+func (jq *JobQueue) AddJob(ctx context.Context, ipAddresses []string, priority model.JobPriority, owner string) (string, error) {
+	targets := make([]string, len(ipAddresses))
+	for i, ipAddress := range ipAddresses {
+		targets[i] = utils.CanonicalIPAddress(ipAddress)
 	}
+
+	return jq.addJob(ctx, model.JobKindIPAddress, targets, priority, owner)
+}
+
+//AddDomainJob function - queues the domains to be looked up with the priority on
+//behalf of owner, the authenticated user, returning the id of the job. Waiting for
+//room on a full queue ends when ctx is done.
+func (jq *JobQueue) AddDomainJob(ctx context.Context, domains []string, priority model.JobPriority, owner string) (string, error) {
+	targets := make([]string, len(domains))
+	for i, domain := range domains {
+		targets[i] = utils.NormalizeDomain(domain)
+	}
+
+	return jq.addJob(ctx, model.JobKindDomain, targets, priority, owner)
+}
+
+//addJob stores the job before queuing it, so that the worker always finds the job
+//it updates, and removes it again should the queue be full
+func (jq *JobQueue) addJob(ctx context.Context, kind model.JobKind, targets []string, priority model.JobPriority, owner string) (string, error) {
+	if !priority.IsValid() {
+		priority = model.JobPriorityNormal
+	}
+	j := job{id: uuid.New().String(), kind: kind, priority: priority, owner: owner, targets: targets}
+
+	record := model.Job{
+		ID:       j.id,
+		Kind:     kind,
+		State:    model.JobStateQueued,
+		Priority: priority,
+		Items:    make([]*model.JobItem, len(targets)),
+	}
+	for i, target := range targets {
+		record.Items[i] = &model.JobItem{Target: target, State: model.JobItemStatePending}
+	}
+
+	err := jq.db.InsertJob(&record, owner)
+	if err != nil {
+		return "", err
+	}
+
+	err = jq.push(ctx, j)
+	if err != nil {
+		if errors.Is(err, ErrQueueFull) {
+			log.Printf("queue busy - unable to queue %s job of %s for %s targets: %+v\n", priority, owner, kind, targets)
+		}
+		jq.db.DeleteJob(j.id)
+		return "", err
+	}
+
+	log.Printf("queued %s job %s of %s for %s targets: %+v\n", priority, j.id, owner, kind, targets)
+	return j.id, nil
 }
 
 func (jq *JobQueue) worker() {
 	defer jq.wg.Done()
 	for {
-		select {
-		case <-jq.stopChannel:
-			log.Println("job queue stopping")
+		if !jq.waitResumed() {
+			log.Println("job queue worker stopping while paused")
 			return
+		}
 
-		case ipAddrs := <-jq.jobChannel:
-			log.Printf("job queue begin processing job with ip addresses: %+v\n", ipAddrs)
+		//A worker waiting for a job when the queue is paused takes none, and waits for
+		//the queue to be resumed instead
+		job, ok := jq.queue.pop(jq.stopChannel, jq.pausing())
+		if !ok {
+			if !jq.stopping() {
+				continue
+			}
+			log.Println("job queue worker stopping")
+			if jq.StopPolicy == StopPolicyDrain {
+				jq.drain()
+			}
+			return
+		}
+		jq.processJob(job)
+	}
+}
 
-			for _, ipAddr := range ipAddrs {
-				resp := jq.dnsbl.Lookup(ipAddr)
+//drain processes the jobs left on the queue once it has been stopped
+func (jq *JobQueue) drain() {
+	for {
+		job, ok := jq.queue.tryPop()
+		if !ok {
+			return
+		}
+		jq.processJob(job)
+	}
+}
 
-				respCode := "NXDOMAIN"
-				if resp.Responses[0].Resp != "" {
-					respCode = resp.Responses[0].Resp
-				}
+//QueueDepth function - counts the jobs waiting to be processed, for each priority
+//from HIGH to LOW
+func (jq *JobQueue) QueueDepth() ([]*model.QueueDepth, error) {
+	depth, err := jq.queue.depth()
+	if err != nil {
+		return nil, err
+	}
 
-				DNSBlockListRecord := model.DNSBlockListRecord{
-					UUID:         uuid.New().String(),
-					IPAddress:    ipAddr,
-					ResponseCode: respCode,
-				}
+	queueDepth := make([]*model.QueueDepth, len(model.AllJobPriority))
+	for i, priority := range model.AllJobPriority {
+		queueDepth[i] = &model.QueueDepth{Priority: priority, Jobs: depth[priority]}
+	}
+
+	return queueDepth, nil
+}
 
-				jq.db.UpsertRecord(&DNSBlockListRecord)
+//stopping reports whether the queue has been stopped
+func (jq *JobQueue) stopping() bool {
+	select {
+	case <-jq.stopChannel:
+		return true
+	default:
+		return false
+	}
+}
 
-				log.Printf("job queue completed processing for ip address: %s\n", ipAddr)
+//abandoning reports whether the queue has been stopped with StopPolicyAbandon
+func (jq *JobQueue) abandoning() bool {
+	select {
+	case <-jq.stopChannel:
+		return jq.StopPolicy == StopPolicyAbandon
+	default:
+		return false
+	}
+}
+
+//processJob looks up the targets of the job, up to JobParallelism at once, recording
+//the outcome of each lookup in the job. The job fails if any of its lookups fail.
+//Once the queue is being abandoned, or is stopped while paused, no further lookups
+//are started, and the job is released with its remaining targets pending. Once the
+//job is cancelled no further lookups are started either, its remaining targets
+//being left cancelled.
+func (jq *JobQueue) processJob(j job) {
+	log.Printf("job queue begin processing job %s with %s targets: %+v\n", j.id, j.kind, j.targets)
+
+	jq.db.UpdateJobState(j.id, model.JobStateRunning)
+	start := time.Now()
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	abandoned := false
+	cancelled := false
+	state := model.JobStateCompleted
+	if j.failed {
+		state = model.JobStateFailed
+	}
+
+	pending := j.pending()
+	positions := make(chan int)
+	for w := 0; w < jq.JobParallelism && w < len(pending); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range positions {
+				//A position handed over before the job was cancelled is left cancelled
+				if jq.isCancelled(j.id) {
+					continue
+				}
+				switch jq.processTarget(j, i) {
+				case model.JobItemStateFailed:
+					mu.Lock()
+					state = model.JobStateFailed
+					mu.Unlock()
+				case model.JobItemStatePending:
+					mu.Lock()
+					abandoned = true
+					mu.Unlock()
+				}
 			}
+		}()
+	}
+
+	for _, i := range pending {
+		if jq.isCancelled(j.id) {
+			cancelled = true
+			break
+		}
+		if !jq.waitResumed() || jq.abandoning() {
+			mu.Lock()
+			abandoned = true
+			mu.Unlock()
+			break
+		}
+		positions <- i
+	}
+	close(positions)
+	wg.Wait()
+
+	if cancelled || jq.isCancelled(j.id) {
+		//Marks the targets left pending once the lookups in flight finished
+		jq.db.CancelJob(j.id)
+		jq.queue.ack(j)
+		jq.forgetCancelled(j.id)
+		jq.observeJob(time.Since(start))
+		log.Printf("job queue cancelled job %s with %s targets: %+v\n", j.id, j.kind, j.targets)
+		return
+	}
+
+	if abandoned {
+		log.Printf("job queue abandoned job %s with %s targets: %+v\n", j.id, j.kind, j.targets)
+		jq.queue.release(j)
+		return
+	}
+
+	jq.db.UpdateJobState(j.id, state)
+	jq.queue.ack(j)
+	jq.observeJob(time.Since(start))
+
+	log.Printf("job queue completed processing job %s with %s targets: %+v\n", j.id, j.kind, j.targets)
+}
+
+//processTarget looks up the target at position i of the job and records the
+//outcome in the job. A target whose stored record is within the Freshness window is
+//not looked up again, and a target already being looked up for another job shares
+//the outcome of that lookup. Returns JobItemStatePending if the queue is abandoned
+//while waiting to retry, leaving the target pending.
+func (jq *JobQueue) processTarget(j job, i int) model.JobItemState {
+	target := j.targets[i]
+
+	if lookupStatus, ok := jq.fresh(j, target); ok {
+		log.Printf("job queue reused stored record of %s for job %s\n", target, j.id)
+		jq.db.UpdateJobItem(j.id, i, model.JobItemStateCompleted, lookupStatus, true)
+		return model.JobItemStateCompleted
+	}
+
+	result := jq.coalesce(j.kind, target, func() lookupResult {
+		return jq.lookupTarget(j, target)
+	})
+	if result.pending {
+		return model.JobItemStatePending
+	}
+
+	itemState := model.JobItemStateCompleted
+	if result.failed {
+		itemState = model.JobItemStateFailed
+	}
+	jq.db.UpdateJobItem(j.id, i, itemState, result.lookupStatus, false)
+
+	return itemState
+}
+
+//lookupTarget looks up the target of the job, retrying transient failures, and
+//stores the result. A target whose lookup still fails is added to the dead letters.
+func (jq *JobQueue) lookupTarget(j job, target string) lookupResult {
+	var resp dnsbl.Return
+	attempt := 1
+	for {
+		resp = jq.lookup(j.kind, target)
+		if !retryable(resp.LookupStatus) || attempt >= jq.MaxAttempts {
+			break
+		}
+
+		delay := jq.backoff(attempt)
+		log.Printf("job queue retrying lookup %d of %s for job %s in %s, status: %s\n", attempt+1, target, j.id, delay, resp.LookupStatus)
+		if !jq.sleep(delay) || !jq.waitResumed() {
+			return lookupResult{pending: true}
+		}
+		attempt++
+	}
 
-			log.Printf("job queue completed processing job with ip addresses: %+v\n", ipAddrs)
+	var lookupStatus model.LookupStatus
+	var err error
+	switch j.kind {
+	case model.JobKindDomain:
+		lookupStatus, err = jq.processDomain(target, resp)
+	default:
+		lookupStatus, err = jq.processIPAddress(target, resp)
+	}
+
+	failed := err != nil || dnsbl.LookupStatus(lookupStatus).Failed()
+	if failed {
+		jq.db.UpsertDeadLetter(&model.DeadLetter{
+			Target:       target,
+			Kind:         j.kind,
+			JobID:        j.id,
+			Attempts:     attempt,
+			LookupStatus: lookupStatus,
+		})
+	} else {
+		//A dead letter whose target has since been looked up is no longer failing
+		jq.db.DeleteDeadLetters(j.kind, []string{target})
+	}
+
+	return lookupResult{lookupStatus: lookupStatus, failed: failed}
+}
+
+//lookup looks the target up once one of the MaxInFlightLookups lookup slots is free
+func (jq *JobQueue) lookup(kind model.JobKind, target string) dnsbl.Return {
+	jq.lookups <- struct{}{}
+	defer func() { <-jq.lookups }()
+
+	if kind == model.JobKindDomain {
+		return jq.dnsbl.LookupDomain(target)
+	}
+	return jq.dnsbl.Lookup(target)
+}
+
+func (jq *JobQueue) processIPAddress(ipAddr string, resp dnsbl.Return) (model.LookupStatus, error) {
+	DNSBlockListRecord := newRecord(ipAddr, resp)
+
+	err := jq.db.UpsertRecord(DNSBlockListRecord)
+
+	log.Printf("job queue completed processing for ip address: %s\n", ipAddr)
+
+	return DNSBlockListRecord.LookupStatus, err
+}
+
+func (jq *JobQueue) processDomain(domain string, resp dnsbl.Return) (model.LookupStatus, error) {
+	record := newRecord(domain, resp)
+
+	err := jq.db.UpsertDomainRecord(&model.DNSDomainBlockListRecord{
+		UUID:         record.UUID,
+		ResponseCode: record.ResponseCode,
+		Domain:       domain,
+		Listed:       record.Listed,
+		Categories:   record.Categories,
+		Severity:     record.Severity,
+		Reason:       record.Reason,
+		Score:        record.Score,
+		Blocked:      record.Blocked,
+		LookupStatus: record.LookupStatus,
+		Listings:     record.Listings,
+	})
+
+	log.Printf("job queue completed processing for domain: %s\n", domain)
+
+	return record.LookupStatus, err
+}
+
+//newRecord converts the lookup response for an ip address or domain into a record
+func newRecord(key string, resp dnsbl.Return) *model.DNSBlockListRecord {
+	DNSBlockListRecord := model.DNSBlockListRecord{
+		UUID:         uuid.New().String(),
+		IPAddress:    key,
+		ResponseCode: "NXDOMAIN",
+		Listed:       resp.Listed,
+		Categories:   make([]string, 0, len(resp.Categories)),
+		Severity:     model.Severity(resp.Severity.String()),
+		Verdict:      model.Verdict(resp.Verdict),
+		Score:        resp.Score,
+		Blocked:      resp.Blocked,
+		LookupStatus: model.LookupStatus(resp.LookupStatus),
+		Listings:     make([]*model.BlocklistListing, 0, len(resp.Responses)+len(resp.AllowResponses)),
+	}
+
+	for _, category := range resp.Categories {
+		DNSBlockListRecord.Categories = append(DNSBlockListRecord.Categories, category.Name)
+	}
+
+	for _, data := range resp.Responses {
+		listing := newListing(data)
+
+		//The record response code and reason are taken from the first blocklist domain listing the ip address
+		if listing.Listed && DNSBlockListRecord.ResponseCode == "NXDOMAIN" {
+			DNSBlockListRecord.ResponseCode = listing.ResponseCode
+			DNSBlockListRecord.Reason = listing.Reason
 		}
+
+		DNSBlockListRecord.Listings = append(DNSBlockListRecord.Listings, listing)
+	}
+
+	for _, data := range resp.AllowResponses {
+		listing := newListing(data)
+		listing.Allowlist = true
+
+		DNSBlockListRecord.Listings = append(DNSBlockListRecord.Listings, listing)
 	}
+
+	//A failed lookup reports its status in place of the response code
+	if resp.LookupStatus.Failed() {
+		DNSBlockListRecord.ResponseCode = string(resp.LookupStatus)
+	}
+
+	return &DNSBlockListRecord
+}
+
+//newListing converts the lookup response of a single blocklist or allowlist domain into a listing
+func newListing(data dnsbl.Data) *model.BlocklistListing {
+	listing := model.BlocklistListing{
+		BlocklistDomain: data.Name,
+		Listed:          data.Listed,
+		LookupStatus:    model.LookupStatus(data.LookupStatus),
+		ResponseCode:    "NXDOMAIN",
+	}
+	switch {
+	case data.Resp != "":
+		listing.ResponseCode = data.Resp
+	case data.LookupStatus.Failed():
+		listing.ResponseCode = string(data.LookupStatus)
+	}
+	if data.Reason != "" {
+		reason := data.Reason
+		listing.Reason = &reason
+	}
+
+	return &listing
 }
@@ -1,14 +1,20 @@
 package jobqueue
 
 import (
+	"context"
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/egreen64/codingchallenge/config"
 	"github.com/egreen64/codingchallenge/db"
-	"github.com/egreen64/codingchallenge/dnsbl"
+	dnsblpkg "github.com/egreen64/codingchallenge/dnsbl"
+	"github.com/egreen64/codingchallenge/dnsbl/dnsbltest"
+	"github.com/egreen64/codingchallenge/graph/model"
+	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 )
 
@@ -22,11 +28,16 @@ func TestJobqueue(t *testing.T) {
 	//Get config file
 	config := config.GetConfig()
 
+	//Answer blocklist queries from a local server loaded with the zen.spamhaus.org test addresses
+	server := dnsbltest.NewServer(dnsbltest.SpamhausFixtures, "zen.spamhaus.org")
+	defer server.Close()
+	config.Dnsbl.Nameservers = []string{server.Addr}
+
 	//Initialize databse
 	database := db.NewDatabase(config)
 
 	//Instantiate DNS Blocklist instance
-	dnsbl := dnsbl.NewDnsbl(config)
+	dnsbl := dnsblpkg.NewDnsbl(config)
 
 	//Instantiage job queue
 	jobQueue := NewJobQueue(config, dnsbl, database)
@@ -49,19 +60,848 @@ func TestJobqueue(t *testing.T) {
 
 	t.Run("add_jobqueue_success", func(t *testing.T) {
 
-		resp := jobQueue.AddJob([]string{"127.0.0.1"})
-		require.Equal(t, true, resp)
+		jobID, err := jobQueue.AddJob(context.Background(), []string{"127.0.0.1"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+		require.NotEmpty(t, jobID)
 	})
 
-	t.Run("add_jobqueue_failure_queue_full", func(t *testing.T) {
+	t.Run("process_job_success", func(t *testing.T) {
+
+		jobID, err := jobQueue.AddJob(context.Background(), []string{"127.0.0.9", "127.0.0.1"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobKindIPAddress, job.Kind)
+		require.Equal(t, 2, job.Total)
+		require.Equal(t, 2, job.Completed)
+		require.Equal(t, "127.0.0.9", job.Items[0].Target)
+		require.Equal(t, model.JobItemStateCompleted, job.Items[0].State)
+		require.Equal(t, model.LookupStatusListed, *job.Items[0].LookupStatus)
+		require.Equal(t, model.LookupStatusNotListed, *job.Items[1].LookupStatus)
+
+		dblRec, err := database.SelectRecord("127.0.0.9")
+		require.Equal(t, nil, err)
+		require.Equal(t, "127.0.0.2", dblRec.ResponseCode)
+		require.Equal(t, []string{"SBL", "DROP"}, dblRec.Categories)
+		require.Equal(t, "127.0.0.9 is listed", *dblRec.Reason)
+	})
+
+	t.Run("process_job_failure_lookup", func(t *testing.T) {
+
+		failServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.ServFail}})
+		defer failServer.Close()
+
+		failConfig := *config
+		failConfig.Dnsbl.Nameservers = []string{failServer.Addr}
+		failConfig.Dnsbl.QueryRetries = 0
+		failJobQueue := NewJobQueue(&failConfig, dnsblpkg.NewDnsbl(&failConfig), database)
+		defer failJobQueue.Stop()
+
+		jobID, err := failJobQueue.AddJob(context.Background(), []string{"127.0.0.1", "127.0.0.2"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateFailed
+		}, 5*time.Second, 50*time.Millisecond)
+
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, 1, job.Completed)
+		require.Equal(t, 1, job.Failed)
+		require.Equal(t, model.JobItemStateFailed, job.Items[1].State)
+		require.Equal(t, model.LookupStatusError, *job.Items[1].LookupStatus)
+	})
+
+	t.Run("process_job_success_retry", func(t *testing.T) {
+
+		flakyServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.3": {dnsbltest.ServFail}})
+		defer flakyServer.Close()
+
+		retryConfig := *config
+		retryConfig.Dnsbl.Nameservers = []string{flakyServer.Addr}
+		retryConfig.Dnsbl.QueryRetries = 0
+		retryConfig.JobQueue.MaxAttempts = 10
+		retryConfig.JobQueue.RetryBackoffMs = 50
+		retryConfig.JobQueue.RetryMaxBackoffMs = 100
+		retryJobQueue := NewJobQueue(&retryConfig, dnsblpkg.NewDnsbl(&retryConfig), database)
+		defer retryJobQueue.Stop()
+
+		jobID, err := retryJobQueue.AddJob(context.Background(), []string{"127.0.0.3"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		//The blocklist recovers after failing the first lookup
+		require.Eventually(t, func() bool {
+			return flakyServer.Queries() > 0
+		}, 5*time.Second, 50*time.Millisecond)
+		flakyServer.SetFixture("127.0.0.3", []string{"127.0.0.3"})
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.LookupStatusListed, *job.Items[0].LookupStatus)
+	})
+
+	t.Run("process_job_failure_dead_letter", func(t *testing.T) {
+
+		failServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.4": {dnsbltest.ServFail}})
+		defer failServer.Close()
+
+		failConfig := *config
+		failConfig.Dnsbl.Nameservers = []string{failServer.Addr}
+		failConfig.Dnsbl.QueryRetries = 0
+		failConfig.JobQueue.MaxAttempts = 2
+		failConfig.JobQueue.RetryBackoffMs = 10
+		failJobQueue := NewJobQueue(&failConfig, dnsblpkg.NewDnsbl(&failConfig), database)
+		defer failJobQueue.Stop()
+
+		jobID, err := failJobQueue.AddJob(context.Background(), []string{"127.0.0.4"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateFailed
+		}, 5*time.Second, 50*time.Millisecond)
+
+		findDeadLetter := func() *model.DeadLetter {
+			deadLetters, err := database.SelectDeadLetters()
+			require.Equal(t, nil, err)
+			for _, deadLetter := range deadLetters {
+				if deadLetter.Target == "127.0.0.4" {
+					return deadLetter
+				}
+			}
+			return nil
+		}
+
+		deadLetter := findDeadLetter()
+		require.NotNil(t, deadLetter)
+		require.Equal(t, model.JobKindIPAddress, deadLetter.Kind)
+		require.Equal(t, jobID, deadLetter.JobID)
+		require.Equal(t, 2, deadLetter.Attempts)
+		require.Equal(t, model.LookupStatusError, deadLetter.LookupStatus)
+
+		//Retrying the dead letter queues it again
+		jobIDs, err := jobQueue.RetryDeadLetters(context.Background(), []string{"127.0.0.4"}, "")
+		require.Equal(t, nil, err)
+		require.Len(t, jobIDs, 1)
+		require.Nil(t, findDeadLetter())
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobIDs[0])
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+
+		//A dead letter is removed once a later lookup of its target succeeds
+		jobID, err = failJobQueue.AddJob(context.Background(), []string{"127.0.0.4"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateFailed
+		}, 5*time.Second, 50*time.Millisecond)
+		require.NotNil(t, findDeadLetter())
+
+		jobID, err = jobQueue.AddJob(context.Background(), []string{"127.0.0.4"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+		require.Nil(t, findDeadLetter())
+	})
+
+	t.Run("process_job_success_slow_job_does_not_stall_others", func(t *testing.T) {
+
+		slowServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Timeout}, "127.0.0.3": {"127.0.0.3"}})
+		defer slowServer.Close()
+
+		slowConfig := *config
+		slowConfig.Dnsbl.Nameservers = []string{slowServer.Addr}
+		slowConfig.Dnsbl.QueryTimeoutMs = 2000
+		slowConfig.Dnsbl.QueryRetries = 0
+		slowConfig.JobQueue.Workers = 2
+		slowJobQueue := NewJobQueue(&slowConfig, dnsblpkg.NewDnsbl(&slowConfig), database)
+		defer slowJobQueue.Stop()
+
+		slowJobID, err := slowJobQueue.AddJob(context.Background(), []string{"127.0.0.2"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+		jobID, err := slowJobQueue.AddJob(context.Background(), []string{"127.0.0.3"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, time.Second, 50*time.Millisecond)
+
+		slowJob, err := database.SelectJob(slowJobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobStateRunning, slowJob.State)
+	})
+
+	t.Run("stop_jobqueue_success_drain", func(t *testing.T) {
+
+		drainConfig := *config
+		drainConfig.JobQueue.StopPolicy = "drain"
+		drainConfig.JobQueue.Workers = 1
+		drainJobQueue := NewJobQueue(&drainConfig, dnsbl, database)
+
+		var jobIDs []string
+		for i := 0; i < 5; i++ {
+			jobID, err := drainJobQueue.AddJob(context.Background(), []string{"127.0.0.3", "127.0.0.4"}, model.JobPriorityNormal, "")
+			require.Equal(t, nil, err)
+			jobIDs = append(jobIDs, jobID)
+		}
+
+		require.Equal(t, true, drainJobQueue.Stop())
+
+		//Every queued job is processed before the queue stops
+		for _, jobID := range jobIDs {
+			job, err := database.SelectJob(jobID)
+			require.Equal(t, nil, err)
+			require.Equal(t, model.JobStateCompleted, job.State)
+		}
+	})
+
+	t.Run("stop_jobqueue_success_abandon", func(t *testing.T) {
 
-		var resp bool
+		slowServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Timeout}})
+		defer slowServer.Close()
+
+		abandonConfig := *config
+		abandonConfig.Dnsbl.Nameservers = []string{slowServer.Addr}
+		abandonConfig.Dnsbl.QueryTimeoutMs = 200
+		abandonConfig.Dnsbl.QueryRetries = 0
+		abandonConfig.JobQueue.StopPolicy = "abandon"
+		abandonConfig.JobQueue.Workers = 1
+		abandonConfig.JobQueue.JobParallelism = 1
+		abandonJobQueue := NewJobQueue(&abandonConfig, dnsblpkg.NewDnsbl(&abandonConfig), database)
+
+		jobID, err := abandonJobQueue.AddJob(context.Background(), []string{"127.0.0.2", "127.0.0.2", "127.0.0.2", "127.0.0.2", "127.0.0.2"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateRunning
+		}, time.Second, 50*time.Millisecond)
+
+		require.Equal(t, true, abandonJobQueue.Stop())
+
+		//The lookup in flight is finished, the job being failed with the remaining ones
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobStateFailed, job.State)
+		require.Equal(t, model.JobItemStateFailed, job.Items[4].State)
+	})
+
+	t.Run("process_job_success_database_store", func(t *testing.T) {
+
+		storeConfig := *config
+		storeConfig.JobQueue.Store = "database"
+		storeConfig.JobQueue.PollIntervalMs = 10
+		storeJobQueue := NewJobQueue(&storeConfig, dnsbl, database)
+
+		jobID, err := storeJobQueue.AddJob(context.Background(), []string{"127.0.0.2", "127.0.0.1"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+
+		require.Equal(t, true, storeJobQueue.Stop())
+
+		//The processed job is removed from the queue
+		id, err := database.LeaseJob("test", time.Minute)
+		require.Equal(t, nil, err)
+		require.Empty(t, id)
+	})
+
+	t.Run("process_job_success_database_store_resumed", func(t *testing.T) {
+
+		slowServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Timeout}})
+		defer slowServer.Close()
+
+		slowConfig := *config
+		slowConfig.Dnsbl.Nameservers = []string{slowServer.Addr}
+		slowConfig.Dnsbl.QueryTimeoutMs = 200
+		slowConfig.Dnsbl.QueryRetries = 0
+		slowConfig.JobQueue.MaxAttempts = 1
+		slowConfig.JobQueue.Store = "database"
+		slowConfig.JobQueue.PollIntervalMs = 10
+		slowConfig.JobQueue.StopPolicy = "abandon"
+		slowConfig.JobQueue.Workers = 1
+		slowConfig.JobQueue.JobParallelism = 1
+		slowJobQueue := NewJobQueue(&slowConfig, dnsblpkg.NewDnsbl(&slowConfig), database)
+
+		jobID, err := slowJobQueue.AddJob(context.Background(), []string{"127.0.0.2", "127.0.0.2", "127.0.0.2", "127.0.0.2", "127.0.0.2"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateRunning
+		}, time.Second, 50*time.Millisecond)
+
+		require.Equal(t, true, slowJobQueue.Stop())
+
+		//The abandoned job is returned to the queue
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobStateQueued, job.State)
+		require.Equal(t, model.JobItemStateFailed, job.Items[0].State)
+		require.Equal(t, model.JobItemStatePending, job.Items[4].State)
+
+		//and resumed with its pending targets by the next job queue
+		storeConfig := *config
+		storeConfig.JobQueue.Store = "database"
+		storeConfig.JobQueue.PollIntervalMs = 10
+		storeJobQueue := NewJobQueue(&storeConfig, dnsbl, database)
+		defer storeJobQueue.Stop()
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateFailed
+		}, 5*time.Second, 50*time.Millisecond)
+
+		job, err = database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobItemStateFailed, job.Items[0].State)
+		require.Equal(t, model.JobItemStateCompleted, job.Items[4].State)
+		require.Equal(t, model.LookupStatusListed, *job.Items[4].LookupStatus)
+	})
+
+	t.Run("new_queue_failure_store", func(t *testing.T) {
+
+		_, err := newQueue("disk", 1, database)
+		require.EqualError(t, err, "invalid job queue store: disk")
+	})
+
+	t.Run("backoff_success", func(t *testing.T) {
+
+		backoffJobQueue := JobQueue{RetryBackoff: 100 * time.Millisecond, RetryMaxBackoff: time.Second}
+
+		//The delay doubles with each attempt up to the maximum, less up to half of it
+		for attempt, delay := range []time.Duration{100, 200, 400, 800, 1000, 1000} {
+			delay *= time.Millisecond
+			backoff := backoffJobQueue.backoff(attempt + 1)
+			require.GreaterOrEqual(t, int64(backoff), int64(delay/2))
+			require.LessOrEqual(t, int64(backoff), int64(delay))
+		}
+	})
+
+	t.Run("refresh_success_stale_record", func(t *testing.T) {
+
+		err := database.UpsertRecord(&model.DNSBlockListRecord{
+			UUID:         uuid.New().String(),
+			IPAddress:    "127.0.0.10",
+			ResponseCode: "NXDOMAIN",
+			LookupStatus: model.LookupStatusNotListed,
+		})
+		require.Equal(t, nil, err)
+
+		//Every record is stale
+		scheduler := NewScheduler(config, jobQueue, database)
+		scheduler.MaxAge = -time.Minute
+		scheduler.BatchSize = 1000
+
+		jobID := scheduler.refresh()
+		require.NotEmpty(t, jobID)
+
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Contains(t, jobTargets(job), "127.0.0.10")
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State != model.JobStateQueued && job.State != model.JobStateRunning
+		}, 10*time.Second, 50*time.Millisecond)
+
+		//The changed result is added to the history of the record
+		dblRec, err := database.SelectRecord("127.0.0.10")
+		require.Equal(t, nil, err)
+		require.Equal(t, true, dblRec.Listed)
+		require.GreaterOrEqual(t, len(dblRec.History), 2)
+		require.Equal(t, true, dblRec.History[0].Listed)
+		require.Equal(t, false, dblRec.History[1].Listed)
+	})
+
+	t.Run("refresh_success_waits_for_previous_job", func(t *testing.T) {
+
+		//The only worker is kept busy by a lookup that does not get an answer
+		timeoutServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.11": {dnsbltest.Timeout}})
+		defer timeoutServer.Close()
+
+		slowConfig := *config
+		slowConfig.Dnsbl.Nameservers = []string{timeoutServer.Addr}
+		slowConfig.Dnsbl.QueryTimeoutMs = 500
+		slowConfig.Dnsbl.QueryRetries = 0
+		slowConfig.JobQueue.Workers = 1
+		slowConfig.JobQueue.MaxAttempts = 1
+		slowJobQueue := NewJobQueue(&slowConfig, dnsblpkg.NewDnsbl(&slowConfig), database)
+		defer slowJobQueue.Stop()
+
+		scheduler := NewScheduler(&slowConfig, slowJobQueue, database)
+		scheduler.MaxAge = -time.Minute
+		scheduler.BatchSize = 1000
+
+		require.NotEmpty(t, scheduler.refresh())
+		require.Empty(t, scheduler.refresh())
+	})
+
+	t.Run("memory_queue_success_priority_fairness", func(t *testing.T) {
+
+		q := newMemoryQueue(database, 6)
+		for _, j := range []job{
+			{id: "a1", priority: model.JobPriorityNormal, owner: "a"},
+			{id: "a2", priority: model.JobPriorityNormal, owner: "a"},
+			{id: "a3", priority: model.JobPriorityNormal, owner: "a"},
+			{id: "b1", priority: model.JobPriorityNormal, owner: "b"},
+			{id: "c1", priority: model.JobPriorityLow, owner: "c"},
+			{id: "a4", priority: model.JobPriorityHigh, owner: "a"},
+		} {
+			require.Equal(t, nil, q.push(j))
+		}
+		require.Equal(t, ErrQueueFull, q.push(job{id: "b2", priority: model.JobPriorityHigh, owner: "b"}))
+
+		depth, err := q.depth()
+		require.Equal(t, nil, err)
+		require.Equal(t, map[model.JobPriority]int{model.JobPriorityHigh: 1, model.JobPriorityNormal: 4, model.JobPriorityLow: 1}, depth)
+
+		//Jobs are taken by priority, the owners taking turns
+		var ids []string
 		for {
-			resp = jobQueue.AddJob([]string{"127.0.0.1"})
-			if !resp {
+			j, ok := q.tryPop()
+			if !ok {
 				break
 			}
+			ids = append(ids, j.id)
 		}
-		require.Equal(t, false, resp)
+		require.Equal(t, []string{"a4", "a1", "b1", "a2", "a3", "c1"}, ids)
 	})
+
+	t.Run("queue_depth_success", func(t *testing.T) {
+
+		depth, err := jobQueue.QueueDepth()
+		require.Equal(t, nil, err)
+		require.Len(t, depth, 3)
+		require.Equal(t, model.JobPriorityHigh, depth[0].Priority)
+		require.Equal(t, model.JobPriorityLow, depth[2].Priority)
+	})
+
+	t.Run("cancel_job_success_running", func(t *testing.T) {
+
+		slowServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Timeout}})
+		defer slowServer.Close()
+
+		cancelConfig := *config
+		cancelConfig.Dnsbl.Nameservers = []string{slowServer.Addr}
+		cancelConfig.Dnsbl.QueryTimeoutMs = 200
+		cancelConfig.Dnsbl.QueryRetries = 0
+		cancelConfig.JobQueue.MaxAttempts = 1
+		cancelConfig.JobQueue.Workers = 1
+		cancelConfig.JobQueue.JobParallelism = 1
+		cancelJobQueue := NewJobQueue(&cancelConfig, dnsblpkg.NewDnsbl(&cancelConfig), database)
+		defer cancelJobQueue.Stop()
+
+		jobID, err := cancelJobQueue.AddJob(context.Background(), []string{"127.0.0.2", "127.0.0.2", "127.0.0.2", "127.0.0.2", "127.0.0.2"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateRunning
+		}, time.Second, 50*time.Millisecond)
+
+		cancelled, err := cancelJobQueue.CancelJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, true, cancelled)
+
+		//The lookup in flight is finished, the remaining ones are left cancelled
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.Failed == 1
+		}, time.Second, 50*time.Millisecond)
+
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobStateCancelled, job.State)
+		require.Equal(t, 4, job.Cancelled)
+		require.Equal(t, model.JobItemStateCancelled, job.Items[4].State)
+
+		cancelled, err = cancelJobQueue.CancelJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, false, cancelled)
+	})
+
+	t.Run("pause_queue_success", func(t *testing.T) {
+
+		for _, store := range []string{StoreMemory, StoreDatabase} {
+			pauseConfig := *config
+			pauseConfig.JobQueue.Store = store
+			pauseConfig.JobQueue.PollIntervalMs = 10
+			pauseConfig.JobQueue.QueueLength = 2
+			pauseJobQueue := NewJobQueue(&pauseConfig, dnsbl, database)
+
+			//The workers are already waiting for jobs when the queue is paused
+			time.Sleep(50 * time.Millisecond)
+			pauseJobQueue.Pause()
+			require.Equal(t, true, pauseJobQueue.Paused())
+
+			//Jobs are queued until the queue is full, but not looked up
+			jobID, err := pauseJobQueue.AddJob(context.Background(), []string{"127.0.0.2"}, model.JobPriorityNormal, "")
+			require.Equal(t, nil, err)
+			cancelID, err := pauseJobQueue.AddJob(context.Background(), []string{"127.0.0.3"}, model.JobPriorityNormal, "")
+			require.Equal(t, nil, err)
+			_, err = pauseJobQueue.AddJob(context.Background(), []string{"127.0.0.4"}, model.JobPriorityNormal, "")
+			require.True(t, errors.Is(err, ErrQueueFull))
+
+			time.Sleep(100 * time.Millisecond)
+			job, err := database.SelectJob(jobID)
+			require.Equal(t, nil, err)
+			require.Equal(t, model.JobStateQueued, job.State)
+
+			//A queued job is taken off the queue when cancelled
+			cancelled, err := pauseJobQueue.CancelJob(cancelID)
+			require.Equal(t, nil, err)
+			require.Equal(t, true, cancelled)
+
+			depth, err := pauseJobQueue.QueueDepth()
+			require.Equal(t, nil, err)
+			require.Equal(t, 1, depth[1].Jobs)
+
+			job, err = database.SelectJob(cancelID)
+			require.Equal(t, nil, err)
+			require.Equal(t, model.JobStateCancelled, job.State)
+			require.Equal(t, model.JobItemStateCancelled, job.Items[0].State)
+
+			pauseJobQueue.Resume()
+			require.Equal(t, false, pauseJobQueue.Paused())
+
+			require.Eventually(t, func() bool {
+				job, err := database.SelectJob(jobID)
+				return err == nil && job.State == model.JobStateCompleted
+			}, 5*time.Second, 50*time.Millisecond)
+
+			require.Equal(t, true, pauseJobQueue.Stop())
+		}
+	})
+
+	t.Run("process_job_success_fresh_record", func(t *testing.T) {
+
+		jobID, err := jobQueue.AddJob(context.Background(), []string{"127.0.0.10"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+
+		freshConfig := *config
+		freshConfig.JobQueue.FreshnessSeconds = 300
+		freshJobQueue := NewJobQueue(&freshConfig, dnsbl, database)
+		defer freshJobQueue.Stop()
+
+		//The record stored by the previous job is reused instead of being looked up
+		jobID, err = freshJobQueue.AddJob(context.Background(), []string{"127.0.0.10"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, 1, job.Completed)
+		require.Equal(t, 1, job.Cached)
+		require.Equal(t, true, job.Items[0].Cached)
+		require.Equal(t, model.LookupStatusListed, *job.Items[0].LookupStatus)
+
+		//The records refreshed by the scheduler are looked up again
+		jobID, err = freshJobQueue.AddJob(context.Background(), []string{"127.0.0.10"}, model.JobPriorityLow, refreshOwner)
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+
+		job, err = database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, 0, job.Cached)
+		require.Equal(t, false, job.Items[0].Cached)
+
+		//Including when the jobs are queued in the database
+		storeConfig := freshConfig
+		storeConfig.JobQueue.Store = "database"
+		storeConfig.JobQueue.PollIntervalMs = 10
+		storeJobQueue := NewJobQueue(&storeConfig, dnsbl, database)
+		defer storeJobQueue.Stop()
+
+		jobID, err = storeJobQueue.AddJob(context.Background(), []string{"127.0.0.10"}, model.JobPriorityLow, refreshOwner)
+		require.Equal(t, nil, err)
+
+		require.Eventually(t, func() bool {
+			job, err := database.SelectJob(jobID)
+			return err == nil && job.State == model.JobStateCompleted
+		}, 5*time.Second, 50*time.Millisecond)
+
+		job, err = database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, 0, job.Cached)
+	})
+
+	t.Run("coalesce_success", func(t *testing.T) {
+
+		started := make(chan struct{})
+		finish := make(chan struct{})
+		leader := make(chan lookupResult)
+		go func() {
+			leader <- jobQueue.coalesce(model.JobKindIPAddress, "192.0.2.1", func() lookupResult {
+				close(started)
+				<-finish
+				return lookupResult{lookupStatus: model.LookupStatusListed}
+			})
+		}()
+		<-started
+
+		//A lookup of the same target in flight is shared, one of another target is not
+		follower := make(chan lookupResult)
+		go func() {
+			follower <- jobQueue.coalesce(model.JobKindIPAddress, "192.0.2.1", func() lookupResult {
+				return lookupResult{lookupStatus: model.LookupStatusError, failed: true}
+			})
+		}()
+		other := jobQueue.coalesce(model.JobKindDomain, "192.0.2.1", func() lookupResult {
+			return lookupResult{lookupStatus: model.LookupStatusNotListed}
+		})
+		require.Equal(t, model.LookupStatusNotListed, other.lookupStatus)
+
+		//The follower waits for the lookup in flight instead of looking the target up
+		select {
+		case <-follower:
+			t.Fatal("follower did not wait for the lookup in flight")
+		case <-time.After(100 * time.Millisecond):
+		}
+
+		close(finish)
+		require.Equal(t, model.LookupStatusListed, (<-leader).lookupStatus)
+		require.Equal(t, model.LookupStatusListed, (<-follower).lookupStatus)
+	})
+
+	t.Run("lookup_now_success", func(t *testing.T) {
+
+		dblRec, err := jobQueue.LookupNow(context.Background(), "127.0.0.11")
+		require.Equal(t, nil, err)
+		require.Equal(t, "127.0.0.11", dblRec.IPAddress)
+		require.Equal(t, model.LookupStatusListed, dblRec.LookupStatus)
+
+		//The result is stored as the workers store it
+		stored, err := database.SelectRecord("127.0.0.11")
+		require.Equal(t, nil, err)
+		require.Equal(t, dblRec.UUID, stored.UUID)
+		require.Equal(t, dblRec.ResponseCode, stored.ResponseCode)
+	})
+
+	t.Run("lookup_now_failure_timeout", func(t *testing.T) {
+
+		slowServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Timeout}})
+		defer slowServer.Close()
+
+		slowConfig := *config
+		slowConfig.Dnsbl.Nameservers = []string{slowServer.Addr}
+		slowConfig.Dnsbl.QueryTimeoutMs = 5000
+		slowConfig.Dnsbl.QueryRetries = 0
+		slowConfig.LookupNow.TimeoutMs = 100
+		slowJobQueue := NewJobQueue(&slowConfig, dnsblpkg.NewDnsbl(&slowConfig), database)
+		defer slowJobQueue.Stop()
+
+		start := time.Now()
+		_, err := slowJobQueue.LookupNow(context.Background(), "127.0.0.2")
+		require.Equal(t, ErrLookupTimeout, err)
+		require.Less(t, int64(time.Since(start)), int64(time.Second))
+	})
+
+	t.Run("lookup_now_failure_status", func(t *testing.T) {
+
+		refusedServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.11": {dnsbltest.Refused}})
+		defer refusedServer.Close()
+
+		refusedConfig := *config
+		refusedConfig.Dnsbl.Nameservers = []string{refusedServer.Addr}
+		refusedConfig.Dnsbl.QueryRetries = 0
+		refusedJobQueue := NewJobQueue(&refusedConfig, dnsblpkg.NewDnsbl(&refusedConfig), database)
+		defer refusedJobQueue.Stop()
+
+		//The record stored by an earlier lookup is not returned as the result
+		_, err := refusedJobQueue.LookupNow(context.Background(), "127.0.0.11")
+		var failedErr *LookupFailedError
+		require.True(t, errors.As(err, &failedErr))
+		require.Equal(t, model.LookupStatusRefused, failedErr.LookupStatus)
+	})
+
+	t.Run("lookup_now_failure_lookup_slots", func(t *testing.T) {
+
+		slotConfig := *config
+		slotConfig.JobQueue.MaxInFlightLookups = 1
+		slotConfig.LookupNow.TimeoutMs = 100
+		slotJobQueue := NewJobQueue(&slotConfig, dnsbl, database)
+		defer slotJobQueue.Stop()
+
+		//The lookup waits for a lookup slot within its timeout
+		slotJobQueue.lookups <- struct{}{}
+		_, err := slotJobQueue.LookupNow(context.Background(), "127.0.0.11")
+		require.Equal(t, ErrLookupTimeout, err)
+
+		<-slotJobQueue.lookups
+		_, err = slotJobQueue.LookupNow(context.Background(), "127.0.0.11")
+		require.Equal(t, nil, err)
+	})
+
+	t.Run("lookup_now_failure_rate_limited", func(t *testing.T) {
+
+		limitConfig := *config
+		limitConfig.LookupNow.RequestsPerMinute = 1
+		limitConfig.LookupNow.Burst = 2
+		limitJobQueue := NewJobQueue(&limitConfig, dnsbl, database)
+		defer limitJobQueue.Stop()
+
+		for i := 0; i < 2; i++ {
+			_, err := limitJobQueue.LookupNow(context.Background(), "127.0.0.3")
+			require.Equal(t, nil, err)
+		}
+
+		//The lookups are limited apart from the jobs, which are still queued
+		_, err := limitJobQueue.LookupNow(context.Background(), "127.0.0.3")
+		require.True(t, errors.Is(err, ErrRateLimited))
+		var rateErr *RateLimitError
+		require.True(t, errors.As(err, &rateErr))
+		require.Greater(t, int64(rateErr.RetryAfter), int64(50*time.Second))
+
+		_, err = limitJobQueue.AddJob(context.Background(), []string{"127.0.0.3"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+	})
+
+	t.Run("parse_stop_policy_failure", func(t *testing.T) {
+
+		policy, err := ParseStopPolicy("")
+		require.Equal(t, nil, err)
+		require.Equal(t, StopPolicyAbandon, policy)
+
+		_, err = ParseStopPolicy("wait")
+		require.EqualError(t, err, "invalid stop policy: wait")
+	})
+
+	t.Run("parse_admission_policy_failure", func(t *testing.T) {
+
+		policy, err := ParseAdmissionPolicy("")
+		require.Equal(t, nil, err)
+		require.Equal(t, AdmissionReject, policy)
+
+		_, err = ParseAdmissionPolicy("block")
+		require.EqualError(t, err, "invalid admission policy: block")
+	})
+
+	t.Run("add_domain_jobqueue_success", func(t *testing.T) {
+
+		jobID, err := jobQueue.AddDomainJob(context.Background(), []string{"Example.COM."}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		job, err := database.SelectJob(jobID)
+		require.Equal(t, nil, err)
+		require.Equal(t, model.JobKindDomain, job.Kind)
+		require.Equal(t, "example.com", job.Items[0].Target)
+	})
+
+	t.Run("add_jobqueue_failure_queue_full", func(t *testing.T) {
+
+		//The only worker is kept busy by a lookup that does not get an answer
+		timeoutServer := dnsbltest.NewServer(dnsbltest.Fixtures{"127.0.0.2": {dnsbltest.Timeout}})
+		defer timeoutServer.Close()
+
+		fullConfig := *config
+		fullConfig.Dnsbl.Nameservers = []string{timeoutServer.Addr}
+		fullConfig.Dnsbl.QueryTimeoutMs = 500
+		fullConfig.Dnsbl.QueryRetries = 0
+		fullConfig.JobQueue.QueueLength = 2
+		fullConfig.JobQueue.Workers = 1
+		fullJobQueue := NewJobQueue(&fullConfig, dnsblpkg.NewDnsbl(&fullConfig), database)
+		defer fullJobQueue.Stop()
+
+		var jobID string
+		var err error
+		for i := 0; i < 10; i++ {
+			jobID, err = fullJobQueue.AddJob(context.Background(), []string{"127.0.0.2"}, model.JobPriorityNormal, "")
+			if err != nil {
+				break
+			}
+		}
+		require.True(t, errors.Is(err, ErrQueueFull))
+		require.Empty(t, jobID)
+
+		var fullErr *QueueFullError
+		require.True(t, errors.As(err, &fullErr))
+		require.GreaterOrEqual(t, int64(fullErr.RetryAfter), int64(time.Second))
+	})
+
+	t.Run("add_jobqueue_success_admission_wait", func(t *testing.T) {
+
+		waitConfig := *config
+		waitConfig.JobQueue.QueueLength = 1
+		waitConfig.JobQueue.Admission = "wait"
+		waitConfig.JobQueue.AdmissionTimeoutMs = 200
+		waitJobQueue := NewJobQueue(&waitConfig, dnsbl, database)
+		defer waitJobQueue.Stop()
+		waitJobQueue.Pause()
+
+		_, err := waitJobQueue.AddJob(context.Background(), []string{"127.0.0.2"}, model.JobPriorityNormal, "")
+		require.Equal(t, nil, err)
+
+		//The job is rejected once the admission timeout, or the context, ends first
+		start := time.Now()
+		_, err = waitJobQueue.AddJob(context.Background(), []string{"127.0.0.3"}, model.JobPriorityNormal, "")
+		require.True(t, errors.Is(err, ErrQueueFull))
+		require.GreaterOrEqual(t, int64(time.Since(start)), int64(200*time.Millisecond))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		start = time.Now()
+		_, err = waitJobQueue.AddJob(ctx, []string{"127.0.0.3"}, model.JobPriorityNormal, "")
+		require.True(t, errors.Is(err, ErrQueueFull))
+		require.Less(t, int64(time.Since(start)), int64(200*time.Millisecond))
+
+		//The job is queued once a worker makes room for it
+		waitJobQueue.AdmissionTimeout = 5 * time.Second
+		queued := make(chan error)
+		go func() {
+			_, err := waitJobQueue.AddJob(context.Background(), []string{"127.0.0.3"}, model.JobPriorityNormal, "")
+			queued <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		waitJobQueue.Resume()
+
+		select {
+		case err = <-queued:
+			require.Equal(t, nil, err)
+		case <-time.After(time.Second):
+			t.Fatal("job not queued once the queue made room")
+		}
+	})
+}
+
+//jobTargets returns the targets of the job
+func jobTargets(job *model.Job) []string {
+	targets := make([]string, len(job.Items))
+	for i, item := range job.Items {
+		targets[i] = item.Target
+	}
+	return targets
 }
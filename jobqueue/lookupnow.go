@@ -0,0 +1,132 @@
+package jobqueue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/egreen64/codingchallenge/graph/model"
+	"github.com/egreen64/codingchallenge/utils"
+)
+
+const (
+	defaultLookupNowTimeout           = 2 * time.Second
+	defaultLookupNowRequestsPerMinute = 60
+	defaultLookupNowBurst             = 10
+)
+
+//ErrRateLimited is returned when a lookup is refused because too many lookups were
+//requested recently
+var ErrRateLimited = errors.New("lookup rate limit exceeded")
+
+//ErrLookupTimeout is returned when a lookup does not finish within LookupNowTimeout
+var ErrLookupTimeout = errors.New("lookup timed out")
+
+//LookupFailedError type - returned when a lookup produced no result, LookupStatus
+//telling why
+type LookupFailedError struct {
+	LookupStatus model.LookupStatus
+}
+
+func (e *LookupFailedError) Error() string {
+	return fmt.Sprintf("lookup failed with status %s", e.LookupStatus)
+}
+
+//RateLimitError type - returned when a lookup is refused by the rate limit,
+//RetryAfter hinting how long to wait before requesting it again. It matches
+//ErrRateLimited with errors.Is.
+type RateLimitError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+//Unwrap function
+func (e *RateLimitError) Unwrap() error {
+	return ErrRateLimited
+}
+
+//limiter type - a token bucket holding up to burst tokens, refilled at rate tokens
+//per second, a token being taken by each request allowed
+type limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newLimiter(requestsPerMinute int, burst int) *limiter {
+	return &limiter{
+		rate:   float64(requestsPerMinute) / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+//allow takes a token if one is left, otherwise returning how long until the next
+//token is added
+func (l *limiter) allow() (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.tokens = math.Min(l.burst, l.tokens+now.Sub(l.last).Seconds()*l.rate)
+	l.last = now
+
+	if l.tokens >= 1 {
+		l.tokens--
+		return true, 0
+	}
+
+	return false, time.Duration((1 - l.tokens) / l.rate * float64(time.Second))
+}
+
+//LookupNow function - looks the ip address up at once, bypassing the queue, for at
+//most LookupNowTimeout including the wait for one of the MaxInFlightLookups lookup
+//slots, and stores the result as the workers do. The lookups are rate limited apart
+//from the jobs, and are refused with a RateLimitError once the limit is reached. A
+//lookup producing no result returns a LookupFailedError. Returns the stored record.
+func (jq *JobQueue) LookupNow(ctx context.Context, ipAddress string) (*model.DNSBlockListRecord, error) {
+	if ok, retryAfter := jq.lookupNowLimiter.allow(); !ok {
+		return nil, &RateLimitError{RetryAfter: retryAfter}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, jq.LookupNowTimeout)
+	defer cancel()
+
+	select {
+	case jq.lookups <- struct{}{}:
+		defer func() { <-jq.lookups }()
+	case <-ctx.Done():
+		return nil, ErrLookupTimeout
+	}
+
+	ipAddress = utils.CanonicalIPAddress(ipAddress)
+	resp := jq.dnsbl.LookupContext(ctx, ipAddress)
+
+	//A lookup cut short says nothing about the blocklists, and is not stored
+	if ctx.Err() != nil {
+		return nil, ErrLookupTimeout
+	}
+
+	lookupStatus, err := jq.processIPAddress(ipAddress, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	//The stored record keeps the result of an earlier lookup, which is not the one
+	//asked for
+	if resp.LookupStatus.Failed() {
+		return nil, &LookupFailedError{LookupStatus: lookupStatus}
+	}
+	jq.db.DeleteDeadLetters(model.JobKindIPAddress, []string{ipAddress})
+
+	return jq.db.SelectRecord(ipAddress)
+}
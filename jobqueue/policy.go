@@ -0,0 +1,54 @@
+package jobqueue
+
+import "fmt"
+
+//StopPolicy type - decides what happens to the jobs being processed when the job
+//queue is stopped
+type StopPolicy string
+
+//StopPolicy values
+const (
+	//StopPolicyDrain finishes the jobs being processed and those still queued
+	StopPolicyDrain StopPolicy = "drain"
+	//StopPolicyAbandon finishes the lookups in flight, leaving the remaining ip
+	//addresses or domains of the jobs being processed to be resumed by a durable
+	//queue, or failing them otherwise
+	StopPolicyAbandon StopPolicy = "abandon"
+)
+
+//ParseStopPolicy function - an empty name selects StopPolicyAbandon
+func ParseStopPolicy(name string) (StopPolicy, error) {
+	switch policy := StopPolicy(name); policy {
+	case "":
+		return StopPolicyAbandon, nil
+	case StopPolicyDrain, StopPolicyAbandon:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid stop policy: %s", name)
+	}
+}
+
+//AdmissionPolicy type - decides what happens to a job added while the job queue is
+//full
+type AdmissionPolicy string
+
+//AdmissionPolicy values
+const (
+	//AdmissionReject rejects the job at once
+	AdmissionReject AdmissionPolicy = "reject"
+	//AdmissionWait waits for the queue to make room for the job, rejecting it if the
+	//queue is still full after the admission timeout
+	AdmissionWait AdmissionPolicy = "wait"
+)
+
+//ParseAdmissionPolicy function - an empty name selects AdmissionReject
+func ParseAdmissionPolicy(name string) (AdmissionPolicy, error) {
+	switch policy := AdmissionPolicy(name); policy {
+	case "":
+		return AdmissionReject, nil
+	case AdmissionReject, AdmissionWait:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("invalid admission policy: %s", name)
+	}
+}
@@ -0,0 +1,262 @@
+package jobqueue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/egreen64/codingchallenge/db"
+	"github.com/egreen64/codingchallenge/graph/model"
+)
+
+//Job queue stores
+const (
+	//StoreMemory holds the queued jobs in memory, losing them when the service stops
+	StoreMemory = "memory"
+	//StoreDatabase holds the queued jobs in the database, resuming them when the
+	//service starts again
+	StoreDatabase = "database"
+)
+
+//queue interface - holds the jobs waiting to be processed by the workers. Jobs are
+//taken in priority order, and jobs of the same priority from each owner in turn.
+type queue interface {
+	//push queues the job, returning ErrQueueFull if the queue is full
+	push(j job) error
+	//pop waits for the next job, returning false once stop is closed
+	pop(stop, paused <-chan struct{}) (job, bool)
+	//tryPop returns the next job without waiting, returning false if there is none
+	tryPop() (job, bool)
+	//ack removes the processed job from the queue
+	ack(j job)
+	//release returns the job to the queue unfinished, to be processed again
+	release(j job)
+	//remove takes the job off the queue, returning false if it was not waiting
+	remove(id string) bool
+	//wait waits while the queue is full, returning false if ctx is done first
+	wait(ctx context.Context) bool
+	//depth counts the jobs waiting, by priority
+	depth() (map[model.JobPriority]int, error)
+}
+
+//newQueue creates the queue of the store, an empty store selecting StoreMemory
+func newQueue(store string, capacity int, database *db.Database) (queue, error) {
+	switch store {
+	case "", StoreMemory:
+		return newMemoryQueue(database, capacity), nil
+	case StoreDatabase:
+		return newDBQueue(database, capacity), nil
+	default:
+		return nil, fmt.Errorf("invalid job queue store: %s", store)
+	}
+}
+
+//memoryQueue type - queues the jobs in memory, in a list of owners taking turns for
+//each priority. Every queued job holds a token on the ready channel, which the
+//workers wait on. The freed channel is closed, and replaced, whenever a job leaves
+//the queue.
+type memoryQueue struct {
+	mu       sync.Mutex
+	db       *db.Database
+	capacity int
+	count    int
+	ready    chan struct{}
+	freed    chan struct{}
+	turns    map[model.JobPriority]*turns
+}
+
+//turns type - the owners with jobs of a single priority waiting, in the order they
+//take their turns, and their jobs
+type turns struct {
+	owners []string
+	jobs   map[string][]job
+}
+
+func newMemoryQueue(database *db.Database, capacity int) *memoryQueue {
+	q := memoryQueue{
+		db:       database,
+		capacity: capacity,
+		ready:    make(chan struct{}, capacity),
+		freed:    make(chan struct{}),
+		turns:    map[model.JobPriority]*turns{},
+	}
+	for _, priority := range model.AllJobPriority {
+		q.turns[priority] = &turns{jobs: map[string][]job{}}
+	}
+
+	return &q
+}
+
+func (q *memoryQueue) push(j job) error {
+	q.mu.Lock()
+	if q.count >= q.capacity {
+		q.mu.Unlock()
+		return ErrQueueFull
+	}
+
+	t := q.turns[j.priority]
+	if len(t.jobs[j.owner]) == 0 {
+		t.owners = append(t.owners, j.owner)
+	}
+	t.jobs[j.owner] = append(t.jobs[j.owner], j)
+	q.count++
+	q.mu.Unlock()
+
+	q.ready <- struct{}{}
+
+	return nil
+}
+
+//pop waits for a token, and takes the next job. There may be none left, should the
+//job of the token have been removed before the token could be taken back. No job is
+//taken once stop or paused is closed.
+func (q *memoryQueue) pop(stop, paused <-chan struct{}) (job, bool) {
+	for {
+		select {
+		case <-stop:
+			return job{}, false
+		case <-paused:
+			return job{}, false
+		default:
+		}
+
+		select {
+		case <-q.ready:
+			if j, ok := q.next(); ok {
+				return j, true
+			}
+		case <-stop:
+			return job{}, false
+		case <-paused:
+			return job{}, false
+		}
+	}
+}
+
+func (q *memoryQueue) tryPop() (job, bool) {
+	for {
+		select {
+		case <-q.ready:
+			if j, ok := q.next(); ok {
+				return j, true
+			}
+		default:
+			return job{}, false
+		}
+	}
+}
+
+//next takes the next job of the owner whose turn it is at the highest priority
+//waiting, the owner taking its next turn after the other owners
+func (q *memoryQueue) next() (job, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, priority := range model.AllJobPriority {
+		t := q.turns[priority]
+		if len(t.owners) == 0 {
+			continue
+		}
+
+		owner := t.owners[0]
+		j := t.jobs[owner][0]
+		t.jobs[owner] = t.jobs[owner][1:]
+		t.owners = t.owners[1:]
+		if len(t.jobs[owner]) > 0 {
+			t.owners = append(t.owners, owner)
+		} else {
+			delete(t.jobs, owner)
+		}
+		q.count--
+		q.free()
+
+		return j, true
+	}
+
+	return job{}, false
+}
+
+//free wakes up those waiting for the queue to make room
+func (q *memoryQueue) free() {
+	close(q.freed)
+	q.freed = make(chan struct{})
+}
+
+func (q *memoryQueue) wait(ctx context.Context) bool {
+	q.mu.Lock()
+	freed := q.freed
+	full := q.count >= q.capacity
+	q.mu.Unlock()
+
+	if !full {
+		return true
+	}
+
+	select {
+	case <-freed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+func (q *memoryQueue) ack(j job) {}
+
+//release marks the job failed, as it cannot outlive the service to be processed
+//again, just as the jobs left unfinished are when the service next starts
+func (q *memoryQueue) release(j job) {
+	q.db.FailJob(j.id)
+}
+
+//remove takes the job off the list of its owner, and takes back a token if one is
+//left. Otherwise a worker holds the token, and finds one job fewer.
+func (q *memoryQueue) remove(id string) bool {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for _, t := range q.turns {
+		for owner, jobs := range t.jobs {
+			for i, j := range jobs {
+				if j.id != id {
+					continue
+				}
+
+				t.jobs[owner] = append(jobs[:i:i], jobs[i+1:]...)
+				if len(t.jobs[owner]) == 0 {
+					delete(t.jobs, owner)
+					for k, o := range t.owners {
+						if o == owner {
+							t.owners = append(t.owners[:k:k], t.owners[k+1:]...)
+							break
+						}
+					}
+				}
+				q.count--
+				q.free()
+
+				select {
+				case <-q.ready:
+				default:
+				}
+
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+func (q *memoryQueue) depth() (map[model.JobPriority]int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	depth := map[model.JobPriority]int{}
+	for priority, t := range q.turns {
+		for _, jobs := range t.jobs {
+			depth[priority] += len(jobs)
+		}
+	}
+
+	return depth, nil
+}
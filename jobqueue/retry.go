@@ -0,0 +1,99 @@
+package jobqueue
+
+import (
+	"context"
+	"math/rand"
+	"time"
+
+	"github.com/egreen64/codingchallenge/dnsbl"
+	"github.com/egreen64/codingchallenge/graph/model"
+	"github.com/egreen64/codingchallenge/utils"
+)
+
+const (
+	defaultMaxAttempts     = 3
+	defaultRetryBackoff    = 200 * time.Millisecond
+	defaultRetryMaxBackoff = 5 * time.Second
+)
+
+//retryable reports whether a lookup with the status may succeed when retried. A
+//REFUSED lookup is not retried, as the blocklist would refuse it again.
+func retryable(status dnsbl.LookupStatus) bool {
+	return status == dnsbl.StatusError || status == dnsbl.StatusTimeout
+}
+
+//backoff returns the delay before the next lookup following the failed attempt,
+//doubling from RetryBackoff up to RetryMaxBackoff. A random jitter of up to half
+//the delay keeps the retries of the lookups failed together from arriving together.
+func (jq *JobQueue) backoff(attempt int) time.Duration {
+	delay := jq.RetryBackoff
+	for i := 1; i < attempt && delay < jq.RetryMaxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > jq.RetryMaxBackoff {
+		delay = jq.RetryMaxBackoff
+	}
+
+	half := delay / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
+
+//sleep waits for the delay, returning false early if the queue is being abandoned
+func (jq *JobQueue) sleep(delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-jq.stopChannel:
+		if jq.StopPolicy == StopPolicyAbandon {
+			return false
+		}
+		<-timer.C
+		return true
+	}
+}
+
+//RetryDeadLetters function - queues the dead letters of the targets again on behalf of
+//owner, or all of them if no targets are given, removing them once queued. Returns
+//the ids of the jobs queued, one for the ip addresses and one for the domains.
+func (jq *JobQueue) RetryDeadLetters(ctx context.Context, targets []string, owner string) ([]string, error) {
+	deadLetters, err := jq.db.SelectDeadLetters()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := make(map[string]bool, len(targets))
+	for _, target := range targets {
+		if utils.IsValidIPAddress(target) {
+			selected[utils.CanonicalIPAddress(target)] = true
+		} else {
+			selected[utils.NormalizeDomain(target)] = true
+		}
+	}
+
+	retries := map[model.JobKind][]string{}
+	for _, deadLetter := range deadLetters {
+		if len(targets) == 0 || selected[deadLetter.Target] {
+			retries[deadLetter.Kind] = append(retries[deadLetter.Kind], deadLetter.Target)
+		}
+	}
+
+	jobIDs := []string{}
+	for _, kind := range []model.JobKind{model.JobKindIPAddress, model.JobKindDomain} {
+		if len(retries[kind]) == 0 {
+			continue
+		}
+
+		jobID, err := jq.addJob(ctx, kind, retries[kind], model.JobPriorityNormal, owner)
+		if err != nil {
+			return jobIDs, err
+		}
+		jobIDs = append(jobIDs, jobID)
+
+		jq.db.DeleteDeadLetters(kind, retries[kind])
+	}
+
+	return jobIDs, nil
+}
@@ -0,0 +1,129 @@
+package jobqueue
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/egreen64/codingchallenge/config"
+	"github.com/egreen64/codingchallenge/db"
+	"github.com/egreen64/codingchallenge/graph/model"
+)
+
+const (
+	defaultRefreshMaxAge    = 24 * time.Hour
+	defaultRefreshInterval  = time.Minute
+	defaultRefreshBatchSize = 100
+
+	//refreshOwner is the owner of the jobs queued by the scheduler
+	refreshOwner = "refresh"
+)
+
+//Scheduler type - keeps the records fresh by queuing the ip addresses not looked up
+//for MaxAge to be looked up again. Every Interval up to BatchSize of them are queued
+//as a single job, least recently looked up first, and no further job is queued until
+//that job has finished, bounding the queries sent to the blocklists on behalf of a
+//large database. The jobs are queued with LOW priority, on behalf of the refresh
+//owner, so that they do not hold up the jobs enqueued by users. Successive batches
+//continue where the previous one ended, so that records whose lookups keep failing
+//do not hold up the others.
+type Scheduler struct {
+	MaxAge      time.Duration
+	Interval    time.Duration
+	BatchSize   int
+	jobQueue    *JobQueue
+	db          *db.Database
+	cursor      *db.StaleRecord
+	jobID       string
+	stopChannel chan struct{}
+	wg          sync.WaitGroup
+}
+
+//NewScheduler function
+func NewScheduler(config *config.File, jobQueue *JobQueue, db *db.Database) *Scheduler {
+	scheduler := Scheduler{
+		MaxAge:      time.Duration(config.Refresh.MaxAgeSeconds) * time.Second,
+		Interval:    time.Duration(config.Refresh.IntervalSeconds) * time.Second,
+		BatchSize:   config.Refresh.BatchSize,
+		jobQueue:    jobQueue,
+		db:          db,
+		stopChannel: make(chan struct{}),
+	}
+	if scheduler.MaxAge <= 0 {
+		scheduler.MaxAge = defaultRefreshMaxAge
+	}
+	if scheduler.Interval <= 0 {
+		scheduler.Interval = defaultRefreshInterval
+	}
+	if scheduler.BatchSize <= 0 {
+		scheduler.BatchSize = defaultRefreshBatchSize
+	}
+
+	return &scheduler
+}
+
+//Start function
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stopChannel:
+				return
+			case <-ticker.C:
+				s.refresh()
+			}
+		}
+	}()
+	log.Printf("refresh scheduler started, max age: %s, interval: %s, batch size: %d\n", s.MaxAge, s.Interval, s.BatchSize)
+}
+
+//Stop function
+func (s *Scheduler) Stop() {
+	close(s.stopChannel)
+	s.wg.Wait()
+	log.Println("refresh scheduler stopped")
+}
+
+//refresh queues the next batch of stale records, unless the job queued for the
+//previous batch has not finished yet. Returns the id of the job queued, if any.
+func (s *Scheduler) refresh() string {
+	if s.jobID != "" {
+		job, err := s.db.SelectJob(s.jobID)
+		if err == nil && (job.State == model.JobStateQueued || job.State == model.JobStateRunning) {
+			return ""
+		}
+		s.jobID = ""
+	}
+
+	records, err := s.db.SelectStaleRecords(time.Now().Add(-s.MaxAge), s.cursor, s.BatchSize)
+	if err != nil || len(records) == 0 {
+		s.cursor = nil
+		return ""
+	}
+
+	ipAddresses := make([]string, len(records))
+	for i, record := range records {
+		ipAddresses[i] = record.IPAddress
+	}
+
+	jobID, err := s.jobQueue.AddJob(context.Background(), ipAddresses, model.JobPriorityLow, refreshOwner)
+	if err != nil {
+		log.Printf("refresh scheduler unable to queue %d stale records, error: %s\n", len(records), err)
+		return ""
+	}
+	log.Printf("refresh scheduler queued job %s for %d stale records\n", jobID, len(records))
+
+	//Start again from the least recently looked up record once every stale record was queued
+	s.cursor = &records[len(records)-1]
+	if len(records) < s.BatchSize {
+		s.cursor = nil
+	}
+	s.jobID = jobID
+
+	return jobID
+}
@@ -2,7 +2,7 @@ package main
 
 import (
 	"context"
-	"fmt"
+	"encoding/json"
 	"log"
 	"net/http"
 	"os"
@@ -16,6 +16,7 @@ import (
 	"github.com/egreen64/codingchallenge/config"
 	"github.com/egreen64/codingchallenge/db"
 	"github.com/egreen64/codingchallenge/dnsbl"
+	"github.com/egreen64/codingchallenge/dnsserver"
 	"github.com/egreen64/codingchallenge/graph"
 	"github.com/egreen64/codingchallenge/graph/generated"
 	"github.com/egreen64/codingchallenge/jobqueue"
@@ -39,12 +40,39 @@ func main() {
 	//Initialize databse
 	database := db.NewDatabase(config)
 
+	//Jobs left unfinished by the previous instance are no longer queued, unless held
+	//by a durable job queue
+	count, err := database.FailUnfinishedJobs()
+	if err != nil {
+		log.Fatalf("unable to update unfinished jobs, error: %s", err)
+	}
+	if count > 0 {
+		log.Printf("marked %d unfinished jobs as failed\n", count)
+	}
+
 	//Instantiate DNS Blocklist instance
 	dnsbl := dnsbl.NewDnsbl(config)
 
 	//Instantiage job queue
 	jobQueue := jobqueue.NewJobQueue(config, dnsbl, database)
 
+	//Start refreshing stale records
+	var scheduler *jobqueue.Scheduler
+	if config.Refresh.Enabled {
+		scheduler = jobqueue.NewScheduler(config, jobQueue, database)
+		scheduler.Start()
+	}
+
+	//Start DNS server
+	var dnsServer *dnsserver.DNSServer
+	if config.DNSServer.Enabled {
+		dnsServer = dnsserver.NewDNSServer(config, database)
+		err = dnsServer.Start()
+		if err != nil {
+			log.Fatalf("unable to start dns server, error: %s", err)
+		}
+	}
+
 	//Initialize resolver
 	resolver := graph.Resolver{
 		Config:   config,
@@ -73,7 +101,14 @@ func main() {
 	go func() {
 		<-mainCtx.Done() //container going down
 		log.Printf("%s recieved termination signal. shutting down...", os.Args[0])
+		if scheduler != nil {
+			scheduler.Stop()
+		}
 		jobQueue.Stop()
+		if dnsServer != nil {
+			dnsServer.Stop()
+		}
+		dnsbl.Stop()
 		database.CloseDatabase()
 		log.Printf("%s shutdown complete", os.Args[0])
 		os.Exit(1)
@@ -91,8 +126,8 @@ func main() {
 	//Initialize graphql handler functions
 	router.Handle("/graphql", playground.Handler("GraphQL playground", "/query"))
 	router.Handle("/query", srv)
-	router.HandleFunc("/liveness", LivenessCheck)
-	router.HandleFunc("/readiness", ReadinessCheck)
+	router.HandleFunc("/liveness", LivenessCheck(dnsbl))
+	router.HandleFunc("/readiness", ReadinessCheck(dnsbl))
 
 	//Initialize listening port
 	port := os.Getenv("PORT")
@@ -108,22 +143,47 @@ func main() {
 	}
 }
 
+//health type - body of the health check responses
+type health struct {
+	Status     string                  `json:"status"`
+	DnsblCache dnsbl.CacheStats        `json:"dnsbl_cache"`
+	Warnings   []dnsbl.SentinelWarning `json:"warnings,omitempty"`
+}
+
 //LivenessCheck function
-func LivenessCheck(res http.ResponseWriter, req *http.Request) {
-	res.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
-	res.Header().Add("Access-Control-Allow-Origin", "*")
-	res.Header().Add("Access-Control-Allow-Methods", "*")
-	res.Header().Add("Access-Control-Allow-Headers", "Content-Type")
-	res.Header().Add("Access-Control-Max-Age", "3600")
-	fmt.Fprintf(res, "ok")
+func LivenessCheck(d *dnsbl.Dnsbl) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		writeHealth(res, health{
+			Status:     "ok",
+			DnsblCache: d.CacheStats(),
+		})
+	}
+}
+
+//ReadinessCheck function - reports a status of "warning" while blocklist domains are
+//answering with error codes, listing them in the warnings. The service remains ready,
+//as lookups on the other blocklist domains and stored records are unaffected.
+func ReadinessCheck(d *dnsbl.Dnsbl) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		body := health{
+			Status:     "ok",
+			DnsblCache: d.CacheStats(),
+			Warnings:   d.Warnings(),
+		}
+		if len(body.Warnings) > 0 {
+			body.Status = "warning"
+		}
+		writeHealth(res, body)
+	}
 }
 
-//ReadinessCheck function
-func ReadinessCheck(res http.ResponseWriter, req *http.Request) {
+//writeHealth writes the body of a health check response
+func writeHealth(res http.ResponseWriter, body health) {
 	res.Header().Add("Cache-Control", "no-cache, no-store, must-revalidate")
 	res.Header().Add("Access-Control-Allow-Origin", "*")
 	res.Header().Add("Access-Control-Allow-Methods", "*")
 	res.Header().Add("Access-Control-Allow-Headers", "Content-Type")
 	res.Header().Add("Access-Control-Max-Age", "3600")
-	fmt.Fprintf(res, "ok")
+	res.Header().Add("Content-Type", "application/json")
+	json.NewEncoder(res).Encode(body)
 }
@@ -1,9 +1,11 @@
 package main
 
 import (
+	"encoding/json"
 	"io/ioutil"
 	"log"
 	"net/http"
+	"net/http/httptest"
 	"strings"
 	"testing"
 	"time"
@@ -17,8 +19,10 @@ import (
 	"github.com/egreen64/codingchallenge/config"
 	"github.com/egreen64/codingchallenge/db"
 	"github.com/egreen64/codingchallenge/dnsbl"
+	"github.com/egreen64/codingchallenge/dnsbl/dnsbltest"
 	"github.com/egreen64/codingchallenge/graph"
 	"github.com/egreen64/codingchallenge/graph/generated"
+	"github.com/egreen64/codingchallenge/graph/model"
 	"github.com/egreen64/codingchallenge/jobqueue"
 )
 
@@ -29,6 +33,11 @@ func TestCodingChallenge(t *testing.T) {
 	//Read config file
 	config := config.GetConfig()
 
+	//Answer blocklist queries from a local server loaded with the zen.spamhaus.org test addresses
+	server := dnsbltest.NewServer(dnsbltest.SpamhausFixtures, "zen.spamhaus.org")
+	defer server.Close()
+	config.Dnsbl.Nameservers = []string{server.Addr}
+
 	//Initialize databse
 	database := db.NewDatabase(config)
 
@@ -87,16 +96,18 @@ func TestCodingChallenge(t *testing.T) {
 	require.Equal(t, "Bearer", strings.Split(authResp.Authenticate.BearerToken, " ")[0])
 
 	var resp struct {
-		Enqueue bool
+		Enqueue *struct {
+			ID string `json:"id"`
+		}
 	}
 
 	mutation = `
 		mutation {
-			enqueue(ip: ["127.0.0.2", "127.0.0.3", "127.0.0.4", "127.0.0.9", "127.0.0.10", , "127.0.0.11", , "127.0.0.12"]) 
+			enqueue(ip: ["127.0.0.2", "127.0.0.3", "127.0.0.4", "127.0.0.9", "127.0.0.10", , "127.0.0.11", , "127.0.0.12"]) { id }
 		}
 	`
 	c.Post(mutation, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
-	require.Equal(t, true, resp.Enqueue)
+	require.NotEmpty(t, resp.Enqueue.ID)
 
 	//Wait for blocklist jobs to complete
 	time.Sleep(5 * time.Second)
@@ -142,33 +153,382 @@ func TestCodingChallenge(t *testing.T) {
 		require.Equal(t, "", resp.Authenticate.BearerToken)
 	})
 
+	t.Run("authenticate_success_users", func(t *testing.T) {
+		var resp struct {
+			Authenticate struct {
+				BearerToken string `json:"bearer_token"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				authenticate(username: "analyst", password: "analystsecret") 
+				{ 
+					bearer_token 
+				} 
+			}
+		`
+
+		c.MustPost(mutation, &resp)
+
+		//Jobs queued with the token take the turns of the user
+		token := strings.TrimPrefix(resp.Authenticate.BearerToken, "Bearer ")
+		require.Equal(t, "analyst", auth.Owner(token))
+
+		var enqueueResp struct {
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
+		}
+
+		mutation = `
+			mutation {
+				enqueue(ip: ["127.0.0.2"]) { id }
+			}
+		`
+		err := c.Post(mutation, &enqueueResp, client.AddHeader("Authorization", resp.Authenticate.BearerToken))
+		require.Equal(t, nil, err)
+		require.NotEmpty(t, enqueueResp.Enqueue.ID)
+
+		mutation = `
+			mutation {
+				authenticate(username: "analyst", password: "supersecret") 
+				{ 
+					bearer_token 
+				} 
+			}
+		`
+		err = c.Post(mutation, &resp)
+		require.EqualError(t, err, `[{"message":"invalid credentials","path":["authenticate"]}]`)
+	})
+
+	t.Run("pause_queue_failure_not_operator", func(t *testing.T) {
+
+		//The job queued by the operator when the test started
+		jobID := resp.Enqueue.ID
+
+		var authResp struct {
+			Authenticate struct {
+				BearerToken string `json:"bearer_token"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				authenticate(username: "analyst", password: "analystsecret") 
+				{ 
+					bearer_token 
+				} 
+			}
+		`
+		c.MustPost(mutation, &authResp)
+
+		var pauseResp struct {
+			PauseQueue struct {
+				Paused bool `json:"paused"`
+			}
+		}
+
+		mutation = `
+			mutation {
+				pauseQueue { paused }
+			}
+		`
+		err := c.Post(mutation, &pauseResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.EqualError(t, err, `[{"message":"not authorized - operator access required","path":["pauseQueue"]}]`)
+
+		mutation = `
+			mutation {
+				resumeQueue { paused }
+			}
+		`
+		err = c.Post(mutation, &pauseResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.EqualError(t, err, `[{"message":"not authorized - operator access required","path":["resumeQueue"]}]`)
+
+		var cancelResp struct {
+			CancelJob struct {
+				State string `json:"state"`
+			}
+		}
+
+		mutation = `
+			mutation($id: ID!) {
+				cancelJob(id: $id) { state }
+			}
+		`
+		err = c.Post(mutation, &cancelResp, client.Var("id", jobID), client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.EqualError(t, err, `[{"message":"not authorized to cancel job `+jobID+`","path":["cancelJob"]}]`)
+	})
+
 	t.Run("enqueue_success", func(t *testing.T) {
 		var resp struct {
-			Enqueue bool
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
 		}
 
 		mutation := `
 			mutation {
-				enqueue(ip: ["127.0.0.13", "127.0.0.14", "127.0.0.15", "127.0.0.153", "127.0.0.163"]) 
+				enqueue(ip: ["127.0.0.13", "127.0.0.14", "127.0.0.15", "127.0.0.153", "127.0.0.163"]) { id }
 			}
 		`
 		c.Post(mutation, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
-		require.Equal(t, true, resp.Enqueue)
+		require.NotEmpty(t, resp.Enqueue.ID)
+	})
+
+	t.Run("job_success", func(t *testing.T) {
+		var enqueueResp struct {
+			Enqueue *struct {
+				ID    string `json:"id"`
+				State string `json:"state"`
+				Total int    `json:"total"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				enqueue(ip: ["127.0.0.2", "127.0.0.16"]) { id state total }
+			}
+		`
+		c.Post(mutation, &enqueueResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Equal(t, 2, enqueueResp.Enqueue.Total)
+
+		var resp struct {
+			Job *struct {
+				ID        string `json:"id"`
+				Kind      string `json:"kind"`
+				State     string `json:"state"`
+				Completed int    `json:"completed"`
+				Cached    int    `json:"cached"`
+				Items     []struct {
+					Target       string `json:"target"`
+					State        string `json:"state"`
+					LookupStatus string `json:"lookup_status"`
+					Cached       bool   `json:"cached"`
+				}
+			}
+		}
+
+		query := `
+			query($id: ID!) {
+				job(id: $id)
+				{
+					id
+					kind
+					state
+					completed
+					cached
+					items { target state lookup_status cached }
+				}
+			}
+		`
+		require.Eventually(t, func() bool {
+			err := c.Post(query, &resp, client.Var("id", enqueueResp.Enqueue.ID), client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+			return err == nil && resp.Job.State == "COMPLETED"
+		}, 5*time.Second, 50*time.Millisecond)
+
+		require.Equal(t, enqueueResp.Enqueue.ID, resp.Job.ID)
+		require.Equal(t, "IP_ADDRESS", resp.Job.Kind)
+		require.Equal(t, 2, resp.Job.Completed)
+		require.Equal(t, 0, resp.Job.Cached)
+		require.Equal(t, false, resp.Job.Items[0].Cached)
+		require.Equal(t, "127.0.0.2", resp.Job.Items[0].Target)
+		require.Equal(t, "LISTED", resp.Job.Items[0].LookupStatus)
+		require.Equal(t, "NOT_LISTED", resp.Job.Items[1].LookupStatus)
+
+		var unknownResp struct {
+			Job *struct {
+				ID string `json:"id"`
+			}
+		}
+
+		err := c.Post(query, &unknownResp, client.Var("id", "unknown"), client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Equal(t, nil, err)
+		require.Nil(t, unknownResp.Job)
+	})
+
+	t.Run("enqueue_success_priority", func(t *testing.T) {
+		var enqueueResp struct {
+			Enqueue *struct {
+				ID       string `json:"id"`
+				Priority string `json:"priority"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				enqueue(ip: ["127.0.0.3"], priority: HIGH) { id priority }
+			}
+		`
+		c.MustPost(mutation, &enqueueResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Equal(t, "HIGH", enqueueResp.Enqueue.Priority)
+
+		var resp struct {
+			QueueStatus struct {
+				Depth []struct {
+					Priority string `json:"priority"`
+					Jobs     int    `json:"jobs"`
+				}
+				Jobs     int `json:"jobs"`
+				Capacity int `json:"capacity"`
+			}
+		}
+
+		query := `
+			query {
+				queueStatus { depth { priority jobs } jobs capacity }
+			}
+		`
+		c.MustPost(query, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Len(t, resp.QueueStatus.Depth, 3)
+		require.Equal(t, "HIGH", resp.QueueStatus.Depth[0].Priority)
+		require.Equal(t, "NORMAL", resp.QueueStatus.Depth[1].Priority)
+		require.Equal(t, "LOW", resp.QueueStatus.Depth[2].Priority)
+		require.Equal(t, config.JobQueue.QueueLength, resp.QueueStatus.Capacity)
+		require.LessOrEqual(t, resp.QueueStatus.Jobs, resp.QueueStatus.Capacity)
+	})
+
+	t.Run("retry_dead_letters_success", func(t *testing.T) {
+
+		err := database.UpsertDeadLetter(&model.DeadLetter{
+			Target:       "127.0.0.10",
+			Kind:         model.JobKindIPAddress,
+			JobID:        "dead-letter-job",
+			Attempts:     3,
+			LookupStatus: model.LookupStatusTimeout,
+		})
+		require.Equal(t, nil, err)
+
+		var resp struct {
+			DeadLetters []struct {
+				Target       string `json:"target"`
+				Kind         string `json:"kind"`
+				Attempts     int    `json:"attempts"`
+				LookupStatus string `json:"lookup_status"`
+			}
+		}
+
+		query := `
+			query {
+				deadLetters { target kind attempts lookup_status }
+			}
+		`
+		c.MustPost(query, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Len(t, resp.DeadLetters, 1)
+		require.Equal(t, "127.0.0.10", resp.DeadLetters[0].Target)
+		require.Equal(t, "IP_ADDRESS", resp.DeadLetters[0].Kind)
+		require.Equal(t, 3, resp.DeadLetters[0].Attempts)
+		require.Equal(t, "TIMEOUT", resp.DeadLetters[0].LookupStatus)
+
+		var retryResp struct {
+			RetryDeadLetters []struct {
+				ID    string `json:"id"`
+				Total int    `json:"total"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				retryDeadLetters(target: ["127.0.0.10"]) { id total }
+			}
+		`
+		c.MustPost(mutation, &retryResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Len(t, retryResp.RetryDeadLetters, 1)
+		require.Equal(t, 1, retryResp.RetryDeadLetters[0].Total)
+
+		var emptyResp struct {
+			DeadLetters []struct {
+				Target string `json:"target"`
+			}
+		}
+		c.MustPost(query, &emptyResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Empty(t, emptyResp.DeadLetters)
+	})
+
+	t.Run("cancel_job_success", func(t *testing.T) {
+
+		var pauseResp struct {
+			PauseQueue struct {
+				Paused bool `json:"paused"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				pauseQueue { paused }
+			}
+		`
+		c.MustPost(mutation, &pauseResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Equal(t, true, pauseResp.PauseQueue.Paused)
+
+		var enqueueResp struct {
+			Enqueue struct {
+				ID    string `json:"id"`
+				State string `json:"state"`
+			}
+		}
+
+		mutation = `
+			mutation {
+				enqueue(ip: ["127.0.0.5"]) { id state }
+			}
+		`
+		c.MustPost(mutation, &enqueueResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Equal(t, "QUEUED", enqueueResp.Enqueue.State)
+
+		var cancelResp struct {
+			CancelJob struct {
+				State     string `json:"state"`
+				Cancelled int    `json:"cancelled"`
+				Items     []struct {
+					State string `json:"state"`
+				}
+			}
+		}
+
+		mutation = `
+			mutation($id: ID!) {
+				cancelJob(id: $id) { state cancelled items { state } }
+			}
+		`
+		c.MustPost(mutation, &cancelResp, client.Var("id", enqueueResp.Enqueue.ID), client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Equal(t, "CANCELLED", cancelResp.CancelJob.State)
+		require.Equal(t, 1, cancelResp.CancelJob.Cancelled)
+		require.Equal(t, "CANCELLED", cancelResp.CancelJob.Items[0].State)
+
+		err := c.Post(mutation, &cancelResp, client.Var("id", "unknown"), client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.EqualError(t, err, `[{"message":"job unknown not found","path":["cancelJob"]}]`)
+
+		var resumeResp struct {
+			ResumeQueue struct {
+				Paused bool `json:"paused"`
+			}
+		}
+
+		mutation = `
+			mutation {
+				resumeQueue { paused }
+			}
+		`
+		c.MustPost(mutation, &resumeResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Equal(t, false, resumeResp.ResumeQueue.Paused)
 	})
 
 	t.Run("enqueue_success_update", func(t *testing.T) {
 
 		var enqueueResp struct {
-			Enqueue bool
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
 		}
 
 		mutation := `
 			mutation {
-				enqueue(ip: ["127.0.0.122"])
+				enqueue(ip: ["127.0.0.122"]) { id }
 			}
 		`
 		c.Post(mutation, &enqueueResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
-		require.Equal(t, true, enqueueResp.Enqueue)
+		require.NotEmpty(t, enqueueResp.Enqueue.ID)
 
 		time.Sleep(1 * time.Second)
 
@@ -201,7 +561,7 @@ func TestCodingChallenge(t *testing.T) {
 		time.Sleep(1 * time.Second)
 
 		c.Post(mutation, &enqueueResp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
-		require.Equal(t, true, enqueueResp.Enqueue)
+		require.NotEmpty(t, enqueueResp.Enqueue.ID)
 
 		time.Sleep(1 * time.Second)
 
@@ -212,10 +572,26 @@ func TestCodingChallenge(t *testing.T) {
 				UpdatedAt    string `json:"updated_at"`
 				ResponseCode string `json:"response_code"`
 				IPAddress    string `json:"ip_address"`
+				History      []struct {
+					ResponseCode string `json:"response_code"`
+				}
 			}
 		}
 
-		c.Post(query, &getResp2, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		historyQuery := `
+			{
+				getIPDetails(ip:"127.0.0.122")
+				{
+					uuid
+					ip_address
+					created_at
+					updated_at
+					response_code
+					history { response_code }
+				}
+			}
+		`
+		c.Post(historyQuery, &getResp2, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
 		require.Equal(t, "127.0.0.122", getResp2.GetIPDetails.IPAddress)
 		require.Equal(t, "NXDOMAIN", getResp2.GetIPDetails.ResponseCode)
 
@@ -223,64 +599,131 @@ func TestCodingChallenge(t *testing.T) {
 		require.Equal(t, getResp1.GetIPDetails.ResponseCode, getResp2.GetIPDetails.ResponseCode)
 		require.Equal(t, getResp1.GetIPDetails.CreatedAt, getResp2.GetIPDetails.CreatedAt)
 		require.NotEqual(t, getResp1.GetIPDetails.UpdatedAt, getResp2.GetIPDetails.UpdatedAt)
+
+		//The unchanged result is not added to the history again
+		require.Len(t, getResp2.GetIPDetails.History, 1)
+		require.Equal(t, "NXDOMAIN", getResp2.GetIPDetails.History[0].ResponseCode)
 	})
 
 	t.Run("enqueue_failure_no_auth_token", func(t *testing.T) {
 		var resp struct {
-			Enqueue bool
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
 		}
 
 		mutation := `
 			mutation {
-				enqueue(ip: ["127.0.0.3", "127.0.0.4", "127.0.0.5", "127.0.0.153", "127.0.0.163"]) 
+				enqueue(ip: ["127.0.0.3", "127.0.0.4", "127.0.0.5", "127.0.0.153", "127.0.0.163"]) { id }
 			}
 		`
 
 		err := c.Post(mutation, &resp)
 		require.EqualError(t, err, `[{"message":"missing auth token","path":["enqueue"]}]`)
-		require.Equal(t, false, resp.Enqueue)
+		require.Nil(t, resp.Enqueue)
 	})
 
 	t.Run("enqueue_failure_invalid_ip_address", func(t *testing.T) {
 		var resp struct {
-			Enqueue bool
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
 		}
 
 		mutation := `
 			mutation {
-				enqueue(ip: ["127.0.0.256"]) 
+				enqueue(ip: ["127.0.0.256"]) { id }
 			}
 		`
 
 		err := c.Post(mutation, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
-		require.EqualError(t, err, `[{"message":"invalid IPV4 address: 127.0.0.256","path":["enqueue"]},{"message":"validation error(s)","path":["enqueue"]}]`)
-		require.Equal(t, false, resp.Enqueue)
+		require.EqualError(t, err, `[{"message":"invalid IP address: 127.0.0.256","path":["enqueue"]},{"message":"validation error(s)","path":["enqueue"]}]`)
+		require.Nil(t, resp.Enqueue)
 	})
 
 	t.Run("enqueue_failure_invalid_ip_addresses", func(t *testing.T) {
 		var resp struct {
-			Enqueue bool
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				enqueue(ip: ["127.0.0.256", "127.0.0.257"]) { id }
+			}
+		`
+
+		err := c.Post(mutation, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.EqualError(t, err, `[{"message":"invalid IP address: 127.0.0.256","path":["enqueue"]},{"message":"invalid IP address: 127.0.0.257","path":["enqueue"]},{"message":"validation error(s)","path":["enqueue"]}]`)
+		require.Nil(t, resp.Enqueue)
+	})
+
+	t.Run("enqueue_success_cidr_range", func(t *testing.T) {
+		var resp struct {
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				enqueue(ip: ["127.0.1.0/30", "127.0.1.2-127.0.1.5", "2001:db8::/126"]) { id }
+			}
+		`
+
+		err := c.Post(mutation, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.Equal(t, nil, err)
+		require.NotEmpty(t, resp.Enqueue.ID)
+	})
+
+	t.Run("enqueue_failure_invalid_cidr_range", func(t *testing.T) {
+		var resp struct {
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
+		}
+
+		mutation := `
+			mutation {
+				enqueue(ip: ["127.0.1.0/33", "127.0.1.5-127.0.1.2"]) { id }
+			}
+		`
+
+		err := c.Post(mutation, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.EqualError(t, err, `[{"message":"invalid CIDR block: 127.0.1.0/33","path":["enqueue"]},{"message":"invalid IP address range: 127.0.1.5-127.0.1.2","path":["enqueue"]},{"message":"validation error(s)","path":["enqueue"]}]`)
+		require.Nil(t, resp.Enqueue)
+	})
+
+	t.Run("enqueue_failure_range_too_large", func(t *testing.T) {
+		var resp struct {
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
 		}
 
 		mutation := `
 			mutation {
-				enqueue(ip: ["127.0.0.256", "127.0.0.257"]) 
+				enqueue(ip: ["10.0.0.0/16"]) { id }
 			}
 		`
 
 		err := c.Post(mutation, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
-		require.EqualError(t, err, `[{"message":"invalid IPV4 address: 127.0.0.256","path":["enqueue"]},{"message":"invalid IPV4 address: 127.0.0.257","path":["enqueue"]},{"message":"validation error(s)","path":["enqueue"]}]`)
-		require.Equal(t, false, resp.Enqueue)
+		require.EqualError(t, err, `[{"message":"too many IP addresses: 65536 requested, at most 1024 can be enqueued at once","path":["enqueue"]}]`)
+		require.Nil(t, resp.Enqueue)
 	})
 
 	t.Run("enqueue_failure_invalid_queue_busy", func(t *testing.T) {
 		var resp struct {
-			Enqueue bool
+			Enqueue *struct {
+				ID string `json:"id"`
+			}
 		}
 
+		//Each job holds enough addresses to keep the workers busy while the queue fills up
 		mutation := `
 			mutation {
-				enqueue(ip: ["127.0.0.255"])
+				enqueue(ip: ["127.0.4.0/22"]) { id }
 			}
 		`
 
@@ -290,11 +733,49 @@ func TestCodingChallenge(t *testing.T) {
 			if err = c.Post(mutation, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken)); err != nil {
 				break
 			}
-			resp.Enqueue = false
+			resp.Enqueue = nil
 			count++
 		}
-		require.EqualError(t, err, `[{"message":"unable to queue job - job queue is curently full. please try again","path":["enqueue"]}]`)
-		require.Equal(t, false, resp.Enqueue)
+		require.Nil(t, resp.Enqueue)
+
+		var gqlErrs []struct {
+			Message    string `json:"message"`
+			Extensions struct {
+				Code       string `json:"code"`
+				RetryAfter int    `json:"retryAfter"`
+			}
+		}
+		require.Equal(t, nil, json.Unmarshal([]byte(err.Error()), &gqlErrs))
+		require.Len(t, gqlErrs, 1)
+		require.Equal(t, "unable to queue job - job queue is curently full. please try again", gqlErrs[0].Message)
+		require.Equal(t, "QUEUE_FULL", gqlErrs[0].Extensions.Code)
+		require.GreaterOrEqual(t, gqlErrs[0].Extensions.RetryAfter, 1)
+	})
+
+	t.Run("lookup_now_success", func(t *testing.T) {
+
+		var resp struct {
+			LookupNow *struct {
+				UUID         string `json:"uuid"`
+				ResponseCode string `json:"response_code"`
+				IPAddress    string `json:"ip_address"`
+				LookupStatus string `json:"lookup_status"`
+			}
+		}
+
+		query := `
+			query($ip: String!) {
+				lookupNow(ip: $ip) { uuid ip_address response_code lookup_status }
+			}
+		`
+		c.MustPost(query, &resp, client.Var("ip", "127.0.0.4"), client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.NotEmpty(t, resp.LookupNow.UUID)
+		require.Equal(t, "127.0.0.4", resp.LookupNow.IPAddress)
+		require.Equal(t, "127.0.0.4", resp.LookupNow.ResponseCode)
+		require.Equal(t, "LISTED", resp.LookupNow.LookupStatus)
+
+		err := c.Post(query, &resp, client.Var("ip", "127.0.0"), client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
+		require.EqualError(t, err, `[{"message":"invalid IP address: 127.0.0","path":["lookupNow"]}]`)
 	})
 
 	t.Run("get_ip_details_success_127.0.0.2", func(t *testing.T) {
@@ -586,7 +1067,20 @@ func TestCodingChallenge(t *testing.T) {
 			}
 		`
 		err := c.Post(query, &resp, client.AddHeader("Authorization", authResp.Authenticate.BearerToken))
-		require.EqualError(t, err, `[{"message":"invalid IPV4 address: 127.0.0.444","path":["getIPDetails"]}]`)
+		require.EqualError(t, err, `[{"message":"invalid IP address: 127.0.0.444","path":["getIPDetails"]}]`)
 		require.Nil(t, resp.GetIPDetails)
 	})
+
+	t.Run("readiness_success", func(t *testing.T) {
+
+		rec := httptest.NewRecorder()
+		ReadinessCheck(dnsbl)(rec, httptest.NewRequest(http.MethodGet, "/readiness", nil))
+		require.Equal(t, http.StatusOK, rec.Code)
+
+		var body health
+		err := json.NewDecoder(rec.Body).Decode(&body)
+		require.Equal(t, nil, err)
+		require.Equal(t, "ok", body.Status)
+		require.Empty(t, body.Warnings)
+	})
 }
@@ -1,8 +1,15 @@
 package utils
 
 import (
+	"bytes"
+	"fmt"
+	"math/big"
 	"net"
 	"os"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/idna"
 )
 
 //FileExists function
@@ -25,3 +32,217 @@ func IsValidIPV4Address(ipAddress string) bool {
 	}
 	return true
 }
+
+//IsValidIPV6Address function
+func IsValidIPV6Address(ipAddress string) bool {
+	addr := net.ParseIP(ipAddress)
+	if addr == nil {
+		return false
+	}
+	if addr.To4() != nil {
+		return false
+	}
+	return true
+}
+
+//IsValidIPAddress function - true for both IPV4 and IPV6 addresses
+func IsValidIPAddress(ipAddress string) bool {
+	return net.ParseIP(ipAddress) != nil
+}
+
+//CanonicalIPAddress function - returns the canonical text form of an IPV4 or IPV6
+//address, e.g. 2001:db8::1 for 2001:DB8:0:0::1. Invalid addresses are returned unchanged.
+func CanonicalIPAddress(ipAddress string) string {
+	addr := net.ParseIP(ipAddress)
+	if addr == nil {
+		return ipAddress
+	}
+	return addr.String()
+}
+
+//ParseReversedIP function - parses the labels of a DNSBL query name preceding the
+//blocklist domain, which are the octets of an IPV4 address or the nibbles of an IPV6
+//address in reverse order, e.g. 2.0.0.127 for 127.0.0.2. Returns nil if the labels
+//are neither.
+func ParseReversedIP(reversed string) net.IP {
+	labels := strings.Split(reversed, ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+
+	switch len(labels) {
+	case net.IPv4len:
+		return net.ParseIP(strings.Join(labels, ".")).To4()
+
+	case 2 * net.IPv6len:
+		ip := make(net.IP, net.IPv6len)
+		for i, label := range labels {
+			nibble, err := strconv.ParseUint(label, 16, 8)
+			if err != nil || len(label) != 1 {
+				return nil
+			}
+			ip[i/2] |= byte(nibble) << (4 * uint(1-i%2))
+		}
+		return ip
+	}
+
+	return nil
+}
+
+//NormalizeDomain function - returns the IDNA (punycode) form of domain in lower case
+//without a trailing dot, e.g. xn--mnchen-3ya.de for München.de. Domains that cannot
+//be converted are returned unchanged.
+func NormalizeDomain(domain string) string {
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil {
+		return domain
+	}
+	return strings.ToLower(ascii)
+}
+
+//IsValidDomain function - true for fully qualified domain names, including
+//internationalized domain names
+func IsValidDomain(domain string) bool {
+	ascii, err := idna.Lookup.ToASCII(strings.TrimSuffix(domain, "."))
+	if err != nil || len(ascii) > 253 {
+		return false
+	}
+
+	labels := strings.Split(ascii, ".")
+	if len(labels) < 2 {
+		return false
+	}
+	for _, label := range labels {
+		if !isValidDomainLabel(label) {
+			return false
+		}
+	}
+	return true
+}
+
+//isValidDomainLabel checks label is 1 to 63 letters, digits or hyphens, not
+//starting or ending with a hyphen
+func isValidDomainLabel(label string) bool {
+	if len(label) == 0 || len(label) > 63 {
+		return false
+	}
+	if label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+//IPRange type - the ip addresses from First to Last inclusive, both of the same family
+type IPRange struct {
+	First net.IP
+	Last  net.IP
+}
+
+//ParseIPRange function - parses a single IPV4 or IPV6 address, a CIDR block such as
+//192.0.2.0/24, or a range of addresses such as 192.0.2.10-192.0.2.20
+func ParseIPRange(ipRange string) (IPRange, error) {
+	switch {
+	case strings.Contains(ipRange, "/"):
+		_, ipNet, err := net.ParseCIDR(ipRange)
+		if err != nil {
+			return IPRange{}, fmt.Errorf("invalid CIDR block: %s", ipRange)
+		}
+		first := ipNet.IP
+		last := make(net.IP, len(first))
+		for i := range first {
+			last[i] = first[i] | ^ipNet.Mask[i]
+		}
+		return IPRange{First: first, Last: last}, nil
+
+	case strings.Contains(ipRange, "-"):
+		parts := strings.SplitN(ipRange, "-", 2)
+		first, last := parseIPFamily(strings.TrimSpace(parts[0])), parseIPFamily(strings.TrimSpace(parts[1]))
+		if first == nil || last == nil || len(first) != len(last) || bytes.Compare(first, last) > 0 {
+			return IPRange{}, fmt.Errorf("invalid IP address range: %s", ipRange)
+		}
+		return IPRange{First: first, Last: last}, nil
+	}
+
+	ip := parseIPFamily(ipRange)
+	if ip == nil {
+		return IPRange{}, fmt.Errorf("invalid IP address: %s", ipRange)
+	}
+	return IPRange{First: ip, Last: ip}, nil
+}
+
+//parseIPFamily parses an ip address into 4 bytes for IPV4 and 16 bytes for IPV6
+func parseIPFamily(ipAddress string) net.IP {
+	ip := net.ParseIP(ipAddress)
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return ip
+}
+
+//Size function - returns the number of addresses in the range, which for IPV6 can
+//exceed any integer type
+func (r IPRange) Size() *big.Int {
+	size := new(big.Int).Sub(new(big.Int).SetBytes(r.Last), new(big.Int).SetBytes(r.First))
+	return size.Add(size, big.NewInt(1))
+}
+
+//Addresses function - returns every address in the range in canonical text form
+func (r IPRange) Addresses() []string {
+	var addrs []string
+	ip := append(net.IP(nil), r.First...)
+	for {
+		addrs = append(addrs, ip.String())
+		if ip.Equal(r.Last) {
+			return addrs
+		}
+		for i := len(ip) - 1; i >= 0; i-- {
+			ip[i]++
+			if ip[i] != 0 {
+				break
+			}
+		}
+	}
+}
+
+//ExpandIPRanges function - expands the ip address ranges, returning each address once
+//in canonical text form. Fails without expanding anything if the ranges hold more
+//than max addresses in total.
+func ExpandIPRanges(ipRanges []IPRange, max int) ([]string, error) {
+	total := new(big.Int)
+	for _, r := range ipRanges {
+		total.Add(total, r.Size())
+	}
+	if total.Cmp(big.NewInt(int64(max))) > 0 {
+		return nil, &RangeTooLargeError{Size: total, Max: max}
+	}
+
+	seen := make(map[string]bool, total.Int64())
+	addrs := make([]string, 0, total.Int64())
+	for _, r := range ipRanges {
+		for _, addr := range r.Addresses() {
+			if !seen[addr] {
+				seen[addr] = true
+				addrs = append(addrs, addr)
+			}
+		}
+	}
+	return addrs, nil
+}
+
+//RangeTooLargeError type - ip address ranges holding more addresses than allowed
+type RangeTooLargeError struct {
+	Size *big.Int
+	Max  int
+}
+
+//Error function
+func (e *RangeTooLargeError) Error() string {
+	return fmt.Sprintf("too many IP addresses: %s requested, at most %d can be enqueued at once", e.Size, e.Max)
+}
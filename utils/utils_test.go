@@ -36,4 +36,120 @@ func TestUtils(t *testing.T) {
 		require.Equal(t, false, resp)
 	})
 
+	t.Run("valid_ipv6_address_success", func(t *testing.T) {
+
+		resp := IsValidIPV6Address("2001:db8::1")
+		require.Equal(t, true, resp)
+	})
+
+	t.Run("valid_ipv6_address_failure", func(t *testing.T) {
+
+		resp := IsValidIPV6Address("127.0.0.1")
+		require.Equal(t, false, resp)
+
+		resp = IsValidIPV6Address("2001:db8::g")
+		require.Equal(t, false, resp)
+	})
+
+	t.Run("valid_ip_address_success", func(t *testing.T) {
+
+		require.Equal(t, true, IsValidIPAddress("127.0.0.1"))
+		require.Equal(t, true, IsValidIPAddress("2001:db8::1"))
+	})
+
+	t.Run("valid_ip_address_failure", func(t *testing.T) {
+
+		resp := IsValidIPAddress("127.0.0.256")
+		require.Equal(t, false, resp)
+	})
+
+	t.Run("canonical_ip_address_success", func(t *testing.T) {
+
+		require.Equal(t, "2001:db8::1", CanonicalIPAddress("2001:DB8:0:0::0001"))
+		require.Equal(t, "127.0.0.1", CanonicalIPAddress("::ffff:127.0.0.1"))
+		require.Equal(t, "127.0.0.256", CanonicalIPAddress("127.0.0.256"))
+	})
+
+	t.Run("parse_reversed_ip_success", func(t *testing.T) {
+
+		require.Equal(t, "127.0.0.2", ParseReversedIP("2.0.0.127").String())
+		require.Equal(t, "2001:db8::1", ParseReversedIP("1.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2").String())
+		require.Nil(t, ParseReversedIP("256.0.0.127"))
+		require.Nil(t, ParseReversedIP("0.0.127"))
+		require.Nil(t, ParseReversedIP("10.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.0.8.b.d.0.1.0.0.2"))
+	})
+
+	t.Run("valid_domain_success", func(t *testing.T) {
+
+		require.Equal(t, true, IsValidDomain("example.com"))
+		require.Equal(t, true, IsValidDomain("mail.Example.com."))
+		require.Equal(t, true, IsValidDomain("münchen.de"))
+	})
+
+	t.Run("valid_domain_failure", func(t *testing.T) {
+
+		require.Equal(t, false, IsValidDomain("localhost"))
+		require.Equal(t, false, IsValidDomain("-example.com"))
+		require.Equal(t, false, IsValidDomain("exa_mple.com"))
+		require.Equal(t, false, IsValidDomain("example..com"))
+		require.Equal(t, false, IsValidDomain(""))
+	})
+
+	t.Run("normalize_domain_success", func(t *testing.T) {
+
+		require.Equal(t, "mail.example.com", NormalizeDomain("Mail.Example.COM."))
+		require.Equal(t, "xn--mnchen-3ya.de", NormalizeDomain("München.de"))
+	})
+
+	t.Run("parse_ip_range_success", func(t *testing.T) {
+
+		r, err := ParseIPRange("192.0.2.77/30")
+		require.Equal(t, nil, err)
+		require.Equal(t, []string{"192.0.2.76", "192.0.2.77", "192.0.2.78", "192.0.2.79"}, r.Addresses())
+
+		r, err = ParseIPRange("192.0.2.254 - 192.0.3.1")
+		require.Equal(t, nil, err)
+		require.Equal(t, []string{"192.0.2.254", "192.0.2.255", "192.0.3.0", "192.0.3.1"}, r.Addresses())
+
+		r, err = ParseIPRange("2001:DB8::1")
+		require.Equal(t, nil, err)
+		require.Equal(t, []string{"2001:db8::1"}, r.Addresses())
+
+		r, err = ParseIPRange("2001:db8::/32")
+		require.Equal(t, nil, err)
+		require.Equal(t, "79228162514264337593543950336", r.Size().String())
+	})
+
+	t.Run("parse_ip_range_failure", func(t *testing.T) {
+
+		_, err := ParseIPRange("192.0.2.0/33")
+		require.EqualError(t, err, "invalid CIDR block: 192.0.2.0/33")
+
+		_, err = ParseIPRange("192.0.2.10-192.0.2.1")
+		require.EqualError(t, err, "invalid IP address range: 192.0.2.10-192.0.2.1")
+
+		_, err = ParseIPRange("192.0.2.1-2001:db8::1")
+		require.EqualError(t, err, "invalid IP address range: 192.0.2.1-2001:db8::1")
+
+		_, err = ParseIPRange("192.0.2.256")
+		require.EqualError(t, err, "invalid IP address: 192.0.2.256")
+	})
+
+	t.Run("expand_ip_ranges_success", func(t *testing.T) {
+
+		first, _ := ParseIPRange("192.0.2.0/31")
+		second, _ := ParseIPRange("192.0.2.1-192.0.2.2")
+
+		addrs, err := ExpandIPRanges([]IPRange{first, second}, 4)
+		require.Equal(t, nil, err)
+		require.Equal(t, []string{"192.0.2.0", "192.0.2.1", "192.0.2.2"}, addrs)
+	})
+
+	t.Run("expand_ip_ranges_failure_too_large", func(t *testing.T) {
+
+		r, _ := ParseIPRange("2001:db8::/64")
+
+		_, err := ExpandIPRanges([]IPRange{r}, 1024)
+		require.EqualError(t, err, "too many IP addresses: 18446744073709551616 requested, at most 1024 can be enqueued at once")
+	})
 }